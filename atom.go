@@ -0,0 +1,232 @@
+package immutable
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+// releasable is implemented by the versions holding references that must be dropped when they are not used anymore,
+// like *hamt.PersistentHAMT and *hamt.PersistentSet. Clone must be cheap and return a version sharing the content.
+type releasable[T any] interface {
+	Clone() T
+	Destroy()
+}
+
+// Atom is a reference to the current version of a persistent data structure, for the "many readers, one writer
+// publishing new versions" pattern. All its methods are safe for concurrent use.
+//
+// An Atom owns the version it holds. The versions passed to it (to NewAtom, Store, CompareAndSwap and as the result
+// of the function given to Swap) must not be used by the caller afterwards, except through Load. For the types with
+// Clone and Destroy methods, like *hamt.PersistentHAMT, Load returns a clone owned by the caller, which should Destroy
+// it when done, and the Atom destroys a version once it is replaced and no Load is still cloning it. Values without
+// these methods, like RRBTree, are simply shared.
+//
+// The zero Atom holds the zero value of T.
+type Atom[T any] struct {
+	current atomic.Pointer[version[T]]
+}
+
+// version is a value stored in an Atom. refs counts the reference of the Atom plus the Load calls in progress on it.
+type version[T any] struct {
+	value T
+	refs  int32
+}
+
+// NewAtom returns an Atom holding v.
+func NewAtom[T any](v T) *Atom[T] {
+	a := &Atom[T]{}
+	a.current.Store(newVersion(v))
+	return a
+}
+
+func newVersion[T any](v T) *version[T] {
+	return &version[T]{value: v, refs: 1}
+}
+
+// acquire adds a reference to the version, unless it was already released.
+func (v *version[T]) acquire() bool {
+	for {
+		refs := atomic.LoadInt32(&v.refs)
+		if refs == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&v.refs, refs, refs+1) {
+			return true
+		}
+	}
+}
+
+func (v *version[T]) release() {
+	if v != nil && atomic.AddInt32(&v.refs, -1) == 0 {
+		destroy(v.value)
+	}
+}
+
+// acquire returns the current version with a new reference, or nil if the Atom was never set.
+func (a *Atom[T]) acquire() *version[T] {
+	for {
+		v := a.current.Load()
+		if v == nil || v.acquire() {
+			return v
+		}
+	}
+}
+
+// Load returns the current version. Versions with Clone and Destroy methods are cloned, see Atom.
+func (a *Atom[T]) Load() T {
+	v := a.acquire()
+	if v == nil {
+		var zero T
+		return zero
+	}
+	defer v.release()
+
+	return own(v.value)
+}
+
+// own returns a copy of the value owned by the caller. A nil version is returned as is.
+func own[T any](v T) T {
+	if r, ok := asReleasable(v); ok {
+		return r.Clone()
+	}
+	return v
+}
+
+// Store replaces the current version with v.
+func (a *Atom[T]) Store(v T) {
+	a.current.Swap(newVersion(v)).release()
+}
+
+// CompareAndSwap replaces the current version with new if it is still old, a value returned by Load, and reports
+// whether it did. Versions are compared by identity: pointers, slices and maps are equal if they point to the same
+// memory, and a clone of a version returned by Load is the same as the original.
+// If the swap fails, new still belongs to the caller.
+func (a *Atom[T]) CompareAndSwap(old, new T) bool {
+	cur := a.acquire()
+	defer cur.release()
+
+	var curValue T
+	if cur != nil {
+		curValue = cur.value
+	}
+
+	if !sameVersion(curValue, old) || !a.current.CompareAndSwap(cur, newVersion(new)) {
+		return false
+	}
+
+	cur.release() // the reference of the Atom
+	return true
+}
+
+// Swap replaces the current version with f(old), retrying with the new current version if another writer changed it
+// in the meantime, and returns the version stored, like Load would. f may run several times and must not have side
+// effects. old belongs to Swap: f can change it and return it, or return a new version, but must not keep it.
+// The versions returned by f and not stored are destroyed, as well as old if it is not stored.
+func (a *Atom[T]) Swap(f func(old T) T) T {
+	for {
+		cur := a.acquire()
+
+		var old T
+		if cur != nil {
+			old = own(cur.value)
+		}
+
+		v := f(old)
+		result := own(v)
+
+		swapped := a.current.CompareAndSwap(cur, newVersion(v))
+		if swapped {
+			cur.release() // the reference of the Atom
+		}
+		cur.release()
+
+		if !sameHandle(old, v) {
+			destroy(old)
+		}
+		if swapped {
+			return result
+		}
+
+		destroy(v)
+		destroy(result)
+	}
+}
+
+// destroy releases the version v if its type has Clone and Destroy methods and v is not nil.
+func destroy[T any](v T) {
+	if r, ok := asReleasable(v); ok {
+		r.Destroy()
+	}
+}
+
+// asReleasable returns v as a releasable, unless its type has no Clone and Destroy methods or v is a nil pointer.
+func asReleasable[T any](v T) (releasable[T], bool) {
+	r, ok := any(v).(releasable[T])
+	if !ok {
+		return nil, false
+	}
+
+	if rv := reflect.ValueOf(r); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return nil, false
+	}
+	return r, true
+}
+
+// sameHandle reports whether a and b are the same value, pointers being compared by address only.
+func sameHandle[T any](a, b T) bool {
+	return identical(reflect.ValueOf(&a).Elem(), reflect.ValueOf(&b).Elem(), false)
+}
+
+// sameVersion reports whether a and b refer to the same version. A pointer is also followed once, so a clone is the
+// same version as its original.
+func sameVersion[T any](a, b T) bool {
+	return identical(reflect.ValueOf(&a).Elem(), reflect.ValueOf(&b).Elem(), true)
+}
+
+// identical compares a and b without following references, except the first pointer if deref is set.
+func identical(a, b reflect.Value, deref bool) bool {
+	switch a.Kind() {
+	case reflect.Pointer:
+		if a.Pointer() == b.Pointer() {
+			return true
+		}
+		return deref && !a.IsNil() && !b.IsNil() && identical(a.Elem(), b.Elem(), false)
+	case reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return a.Pointer() == b.Pointer()
+	case reflect.Slice:
+		return a.Pointer() == b.Pointer() && a.Len() == b.Len()
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return a.Elem().Type() == b.Elem().Type() && identical(a.Elem(), b.Elem(), deref)
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !identical(a.Field(i), b.Field(i), deref) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if !identical(a.Index(i), b.Index(i), deref) {
+				return false
+			}
+		}
+		return true
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() == b.Float()
+	case reflect.Complex64, reflect.Complex128:
+		return a.Complex() == b.Complex()
+	case reflect.String:
+		return a.String() == b.String()
+	}
+
+	return false
+}
@@ -0,0 +1,194 @@
+package immutable
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nnhatnam/immutable/RRBTree"
+	"github.com/nnhatnam/immutable/hamt"
+)
+
+func TestAtomHAMT(t *testing.T) {
+	const (
+		writers   = 4
+		perWriter = 500
+	)
+
+	// f may run several times, the records of the attempts that are not stored are released right away
+	var attempts, releases [writers * perWriter]int32
+	release := func(k, v int) {
+		atomic.AddInt32(&releases[k], 1)
+	}
+
+	a := NewAtom(hamt.NewPersistentHAMT[int, int](hamt.NewDefaultHasher[int]()))
+
+	var wg sync.WaitGroup
+	var done int32
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				k := w*perWriter + i
+				a.Swap(func(old *hamt.PersistentHAMT[int, int]) *hamt.PersistentHAMT[int, int] {
+					atomic.AddInt32(&attempts[k], 1)
+					old.Put(k, k, release)
+					return old
+				}).Destroy()
+			}
+		}(w)
+	}
+
+	var readers sync.WaitGroup
+	for r := 0; r < 2; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for atomic.LoadInt32(&done) == 0 {
+				m := a.Load()
+				count := 0
+				m.Range(func(k, v int) bool {
+					if k != v {
+						t.Errorf("Get(%d) = %d", k, v)
+					}
+					count++
+					return false
+				})
+				if count != m.Len() {
+					t.Errorf("the version has %d keys, want %d", count, m.Len())
+				}
+				m.Destroy()
+			}
+		}()
+	}
+
+	wg.Wait()
+	atomic.StoreInt32(&done, 1)
+	readers.Wait()
+
+	m := a.Load()
+	if m.Len() != writers*perWriter {
+		t.Fatalf("Len() = %d, want %d", m.Len(), writers*perWriter)
+	}
+
+	a.Store(hamt.NewPersistentHAMT[int, int](hamt.NewDefaultHasher[int]()))
+	for k := range releases {
+		if releases[k] != attempts[k]-1 {
+			t.Fatalf("the record of %d was released while a loaded version still uses it", k)
+		}
+	}
+
+	m.Destroy()
+	for k := range releases {
+		if releases[k] != attempts[k] {
+			t.Fatalf("%d records of %d were released, want %d", releases[k], k, attempts[k])
+		}
+	}
+}
+
+func TestAtomCompareAndSwap(t *testing.T) {
+	a := NewAtom(hamt.NewPersistentHAMT[string, int](hamt.NewDefaultHasher[string]()))
+
+	old := a.Load()
+	other := a.Load()
+	other.Set("other", 1)
+
+	next := old.Clone()
+	next.Set("a", 1)
+	// other is a clone of the current version, but it was changed since
+	if a.CompareAndSwap(other, next) {
+		t.Fatalf("CompareAndSwap() should fail with a changed version")
+	}
+
+	if !a.CompareAndSwap(old, next) {
+		t.Fatalf("CompareAndSwap() should succeed with a clone of the current version")
+	}
+	if a.CompareAndSwap(old, old) {
+		t.Fatalf("CompareAndSwap() should fail with a replaced version")
+	}
+
+	if v, ok := a.Load().Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %d, %v, want 1", v, ok)
+	}
+
+	var zero Atom[*hamt.PersistentHAMT[string, int]]
+	if zero.Load() != nil || !zero.CompareAndSwap(nil, other) || zero.Load().Len() != 1 {
+		t.Errorf("the zero Atom should hold nil")
+	}
+}
+
+func TestAtomNil(t *testing.T) {
+	a := NewAtom[*hamt.PersistentHAMT[string, int]](nil)
+	if a.Load() != nil {
+		t.Fatalf("Load() of a nil version should be nil")
+	}
+
+	// from nil to a map and back
+	v := a.Swap(func(old *hamt.PersistentHAMT[string, int]) *hamt.PersistentHAMT[string, int] {
+		if old != nil {
+			t.Errorf("Swap() passed %v, want nil", old)
+		}
+		m := hamt.NewPersistentHAMT[string, int](hamt.NewDefaultHasher[string]())
+		m.Set("a", 1)
+		return m
+	})
+	if v.Len() != 1 {
+		t.Errorf("Swap() = a map of %d entries, want 1", v.Len())
+	}
+	v.Destroy()
+
+	if v := a.Swap(func(old *hamt.PersistentHAMT[string, int]) *hamt.PersistentHAMT[string, int] { return nil }); v != nil {
+		t.Errorf("Swap() = %v, want nil", v)
+	}
+	if a.Load() != nil {
+		t.Errorf("Load() after a Swap() to nil should be nil")
+	}
+
+	a.Store(nil)
+	if !a.CompareAndSwap(nil, nil) {
+		t.Errorf("CompareAndSwap(nil, nil) should succeed on a nil version")
+	}
+}
+
+func TestAtomRRBTree(t *testing.T) {
+	const (
+		writers   = 4
+		perWriter = 300
+	)
+
+	var a Atom[RRBTree.RRBTree[int]]
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				a.Swap(func(old RRBTree.RRBTree[int]) RRBTree.RRBTree[int] {
+					return old.Append(w)
+				})
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	v := a.Load()
+	if v.Len() != writers*perWriter {
+		t.Fatalf("Len() = %d, want %d", v.Len(), writers*perWriter)
+	}
+
+	counts := make([]int, writers)
+	for i := 0; i < v.Len(); i++ {
+		counts[v.Get(i)]++
+	}
+	for w, n := range counts {
+		if n != perWriter {
+			t.Errorf("writer %d appended %d values, want %d", w, n, perWriter)
+		}
+	}
+
+	if !a.CompareAndSwap(v, v.Append(-1)) || a.CompareAndSwap(v, v) || a.Load().Len() != v.Len()+1 {
+		t.Errorf("CompareAndSwap() should compare the versions by identity")
+	}
+}
@@ -0,0 +1,539 @@
+// Package btree implements PersistentBTree, a persistent sorted map.
+//
+// The map is a B-tree whose nodes are shared between versions. Like PersistentHAMT, every node counts the versions
+// and parents referencing it: Clone only adds a reference to the root, and a change copies the nodes on its path that
+// are referenced more than once, changing the others in place.
+package btree
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/exp/constraints"
+)
+
+const (
+	maxItems = 31           // the maximum number of items of a node, a node has at most maxItems+1 children
+	minItems = maxItems / 2 // the minimum number of items of a node other than the root
+)
+
+type item[K any, V any] struct {
+	key   K
+	value V
+}
+
+// node is a node of the tree. A leaf has no children, an internal node has one child more than it has items.
+// The keys of children[i] sort between items[i-1] and items[i].
+type node[K any, V any] struct {
+	items    []item[K, V]
+	children []*node[K, V]
+	refCount int32
+}
+
+func (n *node[K, V]) isLeaf() bool {
+	return len(n.children) == 0
+}
+
+func (n *node[K, V]) incRef() *node[K, V] {
+	if n != nil {
+		atomic.AddInt32(&n.refCount, 1)
+	}
+	return n
+}
+
+func (n *node[K, V]) decRef() {
+	if n == nil {
+		return
+	}
+
+	if atomic.AddInt32(&n.refCount, -1) == 0 {
+		for i, child := range n.children {
+			child.decRef()
+			n.children[i] = nil
+		}
+		n.items = nil
+		n.children = nil
+	}
+}
+
+// mutable returns a node that the caller can change, consuming the reference of the caller to n.
+// n itself is returned if nothing else references it. Otherwise, it is copied with a new reference to each of its
+// children, so they are copied in turn when they are changed.
+func (n *node[K, V]) mutable() *node[K, V] {
+	if atomic.LoadInt32(&n.refCount) == 1 {
+		return n
+	}
+
+	n1 := &node[K, V]{
+		items:    make([]item[K, V], len(n.items), maxItems),
+		refCount: 1,
+	}
+	copy(n1.items, n.items)
+
+	if !n.isLeaf() {
+		n1.children = make([]*node[K, V], len(n.children), maxItems+1)
+		for i, child := range n.children {
+			n1.children[i] = child.incRef()
+		}
+	}
+
+	n.decRef()
+	return n1
+}
+
+// mutableChild makes the i-th child of the mutable node n mutable and returns it.
+func (n *node[K, V]) mutableChild(i int) *node[K, V] {
+	n.children[i] = n.children[i].mutable()
+	return n.children[i]
+}
+
+// PersistentBTree is a persistent map sorted by key.
+type PersistentBTree[K any, V any] struct {
+	root *node[K, V]
+	len  int
+
+	less func(a, b K) bool
+}
+
+// NewPersistentBTree returns an empty map sorted by the natural order of the keys.
+func NewPersistentBTree[K constraints.Ordered, V any]() *PersistentBTree[K, V] {
+	return NewPersistentBTreeFunc[K, V](func(a, b K) bool { return a < b })
+}
+
+// NewPersistentBTreeFunc returns an empty map sorted by less, which must define a strict weak ordering. Keys that
+// are neither less nor greater than each other are considered equal.
+func NewPersistentBTreeFunc[K any, V any](less func(a, b K) bool) *PersistentBTree[K, V] {
+	return &PersistentBTree[K, V]{less: less}
+}
+
+func (t *PersistentBTree[K, V]) Len() int {
+	return t.len
+}
+
+// find returns the index of the first item of n whose key is not less than k, and whether that key is k.
+func (t *PersistentBTree[K, V]) find(n *node[K, V], k K) (int, bool) {
+	lo, hi := 0, len(n.items)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if t.less(n.items[mid].key, k) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo, lo < len(n.items) && !t.less(k, n.items[lo].key)
+}
+
+// Get returns the value of the key k and whether it was found.
+func (t *PersistentBTree[K, V]) Get(k K) (_ V, _ bool) {
+	for n := t.root; n != nil; {
+		i, found := t.find(n, k)
+		if found {
+			return n.items[i].value, true
+		}
+		if n.isLeaf() {
+			break
+		}
+		n = n.children[i]
+	}
+
+	return
+}
+
+// Set sets the value of the key k to v. If the map holds a key equal to k, it is replaced with k.
+func (t *PersistentBTree[K, V]) Set(k K, v V) {
+	if t.root == nil {
+		t.root = &node[K, V]{items: make([]item[K, V], 0, maxItems), refCount: 1}
+	}
+
+	t.root = t.root.mutable()
+
+	if len(t.root.items) == maxItems {
+		root := &node[K, V]{
+			children: append(make([]*node[K, V], 0, maxItems+1), t.root),
+			refCount: 1,
+		}
+		t.split(root, 0)
+		t.root = root
+	}
+
+	if t.insert(t.root, item[K, V]{key: k, value: v}) {
+		t.len++
+	}
+}
+
+// split splits the full i-th child of the mutable node n in two around its middle item, which moves up to n.
+// The child must be mutable.
+func (t *PersistentBTree[K, V]) split(n *node[K, V], i int) {
+	child := n.children[i]
+	mid := maxItems / 2
+
+	right := &node[K, V]{
+		items:    append(make([]item[K, V], 0, maxItems), child.items[mid+1:]...),
+		refCount: 1,
+	}
+	if !child.isLeaf() {
+		// the children are moved to the new node, their references with them
+		right.children = append(make([]*node[K, V], 0, maxItems+1), child.children[mid+1:]...)
+		child.children = truncate(child.children, mid+1)
+	}
+
+	up := child.items[mid]
+	child.items = truncate(child.items, mid)
+
+	n.items = insertAt(n.items, i, up)
+	n.children = insertAt(n.children, i+1, right)
+}
+
+// insert adds or replaces the item it under the mutable node n, which is not full. It reports whether the key is new.
+func (t *PersistentBTree[K, V]) insert(n *node[K, V], it item[K, V]) bool {
+	for {
+		i, found := t.find(n, it.key)
+		if found {
+			n.items[i] = it
+			return false
+		}
+
+		if n.isLeaf() {
+			n.items = insertAt(n.items, i, it)
+			return true
+		}
+
+		if child := n.mutableChild(i); len(child.items) == maxItems {
+			t.split(n, i)
+
+			switch {
+			case !t.less(n.items[i].key, it.key) && !t.less(it.key, n.items[i].key):
+				n.items[i] = it
+				return false
+			case t.less(n.items[i].key, it.key):
+				i++
+			}
+		}
+
+		n = n.children[i]
+	}
+}
+
+// Delete removes the key k and reports whether it was found.
+func (t *PersistentBTree[K, V]) Delete(k K) bool {
+	// nothing is copied when the key doesn't exist
+	if _, ok := t.Get(k); !ok {
+		return false
+	}
+
+	t.root = t.root.mutable()
+	t.remove(t.root, k)
+	t.len--
+
+	if len(t.root.items) == 0 {
+		old := t.root
+		if old.isLeaf() {
+			t.root = nil
+		} else {
+			// the only child takes the reference of the root
+			t.root = old.children[0]
+			old.children = nil
+		}
+	}
+
+	return true
+}
+
+// remove removes the key k, which exists, under the mutable node n. Before going down to a child, remove makes sure
+// the child has more than minItems items, so removing one never leaves a node with too few items.
+func (t *PersistentBTree[K, V]) remove(n *node[K, V], k K) {
+	for {
+		i, found := t.find(n, k)
+
+		if n.isLeaf() {
+			n.items = removeAt(n.items, i)
+			return
+		}
+
+		if len(n.children[i].items) <= minItems {
+			t.grow(n, i)
+			continue // the key may have moved
+		}
+
+		child := n.mutableChild(i)
+		if found {
+			// replace the key with its predecessor, the greatest key of the child
+			n.items[i] = t.removeMax(child)
+			return
+		}
+
+		n = child
+	}
+}
+
+// removeMax removes and returns the greatest item under the mutable node n, which has more than minItems items.
+func (t *PersistentBTree[K, V]) removeMax(n *node[K, V]) item[K, V] {
+	for !n.isLeaf() {
+		i := len(n.children) - 1
+		if len(n.children[i].items) <= minItems {
+			t.grow(n, i)
+			continue
+		}
+		n = n.mutableChild(i)
+	}
+
+	last := n.items[len(n.items)-1]
+	n.items = removeAt(n.items, len(n.items)-1)
+	return last
+}
+
+// grow adds an item to the i-th child of the mutable node n, taking it from a sibling with items to spare or merging
+// the child with a sibling.
+func (t *PersistentBTree[K, V]) grow(n *node[K, V], i int) {
+	switch {
+	case i > 0 && len(n.children[i-1].items) > minItems:
+		// rotate an item from the left sibling through n
+		child, left := n.mutableChild(i), n.mutableChild(i-1)
+
+		child.items = insertAt(child.items, 0, n.items[i-1])
+		n.items[i-1] = left.items[len(left.items)-1]
+		left.items = removeAt(left.items, len(left.items)-1)
+
+		if !left.isLeaf() {
+			child.children = insertAt(child.children, 0, left.children[len(left.children)-1])
+			left.children = removeAt(left.children, len(left.children)-1)
+		}
+
+	case i < len(n.items) && len(n.children[i+1].items) > minItems:
+		// rotate an item from the right sibling through n
+		child, right := n.mutableChild(i), n.mutableChild(i+1)
+
+		child.items = append(child.items, n.items[i])
+		n.items[i] = right.items[0]
+		right.items = removeAt(right.items, 0)
+
+		if !right.isLeaf() {
+			child.children = append(child.children, right.children[0])
+			right.children = removeAt(right.children, 0)
+		}
+
+	default:
+		// merge the child with its right sibling, or with its left sibling if it is the last child
+		if i == len(n.items) {
+			i--
+		}
+		child, right := n.mutableChild(i), n.mutableChild(i+1)
+
+		// right is not referenced anymore, its children are moved with their references
+		child.items = append(append(child.items, n.items[i]), right.items...)
+		child.children = append(child.children, right.children...)
+
+		n.items = removeAt(n.items, i)
+		n.children = removeAt(n.children, i+1)
+	}
+}
+
+// Min returns the smallest key of the map and its value. ok is false if the map is empty.
+func (t *PersistentBTree[K, V]) Min() (k K, v V, ok bool) {
+	n := t.root
+	if n == nil {
+		return
+	}
+
+	for !n.isLeaf() {
+		n = n.children[0]
+	}
+
+	return n.items[0].key, n.items[0].value, true
+}
+
+// Max returns the greatest key of the map and its value. ok is false if the map is empty.
+func (t *PersistentBTree[K, V]) Max() (k K, v V, ok bool) {
+	n := t.root
+	if n == nil {
+		return
+	}
+
+	for !n.isLeaf() {
+		n = n.children[len(n.children)-1]
+	}
+
+	last := n.items[len(n.items)-1]
+	return last.key, last.value, true
+}
+
+// Floor returns the greatest key less than or equal to k and its value. ok is false if there is none.
+func (t *PersistentBTree[K, V]) Floor(k K) (key K, v V, ok bool) {
+	for n := t.root; n != nil; {
+		i, found := t.find(n, k)
+		if found {
+			return n.items[i].key, n.items[i].value, true
+		}
+
+		// items[i-1] is the greatest key less than k in this node, a better candidate may be found in children[i]
+		if i > 0 {
+			key, v, ok = n.items[i-1].key, n.items[i-1].value, true
+		}
+		if n.isLeaf() {
+			break
+		}
+		n = n.children[i]
+	}
+
+	return
+}
+
+// Ceiling returns the smallest key greater than or equal to k and its value. ok is false if there is none.
+func (t *PersistentBTree[K, V]) Ceiling(k K) (key K, v V, ok bool) {
+	for n := t.root; n != nil; {
+		i, found := t.find(n, k)
+		if found {
+			return n.items[i].key, n.items[i].value, true
+		}
+
+		// items[i] is the smallest key greater than k in this node, a better candidate may be found in children[i]
+		if i < len(n.items) {
+			key, v, ok = n.items[i].key, n.items[i].value, true
+		}
+		if n.isLeaf() {
+			break
+		}
+		n = n.children[i]
+	}
+
+	return
+}
+
+// bound is a limit of an iteration. An unset bound doesn't limit anything.
+type bound[K any] struct {
+	key K
+	set bool
+}
+
+// Ascend calls f for every key-value pair in increasing key order, until f returns true.
+func (t *PersistentBTree[K, V]) Ascend(f func(k K, v V) bool) {
+	t.ascend(t.root, bound[K]{}, bound[K]{}, f)
+}
+
+// Descend calls f for every key-value pair in decreasing key order, until f returns true.
+func (t *PersistentBTree[K, V]) Descend(f func(k K, v V) bool) {
+	t.descend(t.root, bound[K]{}, bound[K]{}, f)
+}
+
+// Range calls f for every key-value pair with a key in [from, to), in increasing key order, until f returns true.
+func (t *PersistentBTree[K, V]) Range(from, to K, f func(k K, v V) bool) {
+	t.ascend(t.root, bound[K]{from, true}, bound[K]{to, true}, f)
+}
+
+// RangeReverse calls f for every key-value pair with a key in [from, to), in decreasing key order, until f returns
+// true.
+func (t *PersistentBTree[K, V]) RangeReverse(from, to K, f func(k K, v V) bool) {
+	t.descend(t.root, bound[K]{from, true}, bound[K]{to, true}, f)
+}
+
+// ascend visits the items of n in [from, to) in increasing order. It returns true when the iteration must stop,
+// because f returned true or the keys reached to.
+func (t *PersistentBTree[K, V]) ascend(n *node[K, V], from, to bound[K], f func(k K, v V) bool) bool {
+	if n == nil {
+		return false
+	}
+
+	i := 0
+	if from.set {
+		i, _ = t.find(n, from.key)
+	}
+
+	for ; i <= len(n.items); i++ {
+		if !n.isLeaf() && t.ascend(n.children[i], from, to, f) {
+			return true
+		}
+
+		if i == len(n.items) {
+			break
+		}
+
+		it := n.items[i]
+		if to.set && !t.less(it.key, to.key) {
+			return true
+		}
+		if f(it.key, it.value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// descend visits the items of n in [from, to) in decreasing order. It returns true when the iteration must stop,
+// because f returned true or the keys went below from.
+func (t *PersistentBTree[K, V]) descend(n *node[K, V], from, to bound[K], f func(k K, v V) bool) bool {
+	if n == nil {
+		return false
+	}
+
+	i := len(n.items)
+	if to.set {
+		i, _ = t.find(n, to.key)
+	}
+
+	for ; i >= 0; i-- {
+		if !n.isLeaf() && t.descend(n.children[i], from, to, f) {
+			return true
+		}
+
+		if i == 0 {
+			break
+		}
+
+		it := n.items[i-1]
+		if from.set && t.less(it.key, from.key) {
+			return true
+		}
+		if f(it.key, it.value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Clone returns a copy of the map in O(1). The two maps share their nodes until they are changed.
+func (t *PersistentBTree[K, V]) Clone() *PersistentBTree[K, V] {
+	return &PersistentBTree[K, V]{
+		root: t.root.incRef(),
+		len:  t.len,
+		less: t.less,
+	}
+}
+
+func (t *PersistentBTree[K, V]) Clear() {
+	t.root.decRef()
+	t.root = nil // GC
+	t.len = 0
+}
+
+func (t *PersistentBTree[K, V]) Destroy() {
+	t.Clear()
+}
+
+// insertAt inserts v at index i of s, in place if s has the capacity.
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+// removeAt removes the element at index i of s in place.
+func removeAt[T any](s []T, i int) []T {
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
+
+// truncate shortens s to length n, zeroing the elements dropped so they can be garbage collected.
+func truncate[T any](s []T, n int) []T {
+	var zero T
+	for i := n; i < len(s); i++ {
+		s[i] = zero
+	}
+	return s[:n]
+}
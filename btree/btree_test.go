@@ -0,0 +1,332 @@
+package btree
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+// validateNode checks the invariants of the B-tree under n and returns its height and number of items.
+func validateNode[K any, V any](t *testing.T, tree *PersistentBTree[K, V], n *node[K, V], isRoot bool) (height, count int) {
+	t.Helper()
+
+	if n.refCount <= 0 {
+		t.Fatalf("node with refCount %d is still referenced", n.refCount)
+	}
+
+	if len(n.items) > maxItems || (!isRoot && len(n.items) < minItems) || len(n.items) == 0 {
+		t.Fatalf("node has %d items", len(n.items))
+	}
+
+	for i := 1; i < len(n.items); i++ {
+		if !tree.less(n.items[i-1].key, n.items[i].key) {
+			t.Fatalf("node items are not sorted")
+		}
+	}
+
+	count = len(n.items)
+	if n.isLeaf() {
+		return 0, count
+	}
+
+	if len(n.children) != len(n.items)+1 {
+		t.Fatalf("node has %d items and %d children", len(n.items), len(n.children))
+	}
+
+	for i, child := range n.children {
+		h, c := validateNode(t, tree, child, false)
+		if i > 0 && h != height {
+			t.Fatalf("leaves are not all at the same depth")
+		}
+		height = h
+		count += c
+
+		if i > 0 && !tree.less(n.items[i-1].key, child.items[0].key) {
+			t.Fatalf("child %d has a key not greater than the item before it", i)
+		}
+		if i < len(n.items) && !tree.less(child.items[len(child.items)-1].key, n.items[i].key) {
+			t.Fatalf("child %d has a key not less than the item after it", i)
+		}
+	}
+
+	return height + 1, count
+}
+
+func validateTree(t *testing.T, tree *PersistentBTree[int, int], expected map[int]int) {
+	t.Helper()
+
+	if tree.root != nil {
+		if _, count := validateNode(t, tree, tree.root, true); count != tree.Len() {
+			t.Fatalf("the tree holds %d items, Len() = %d", count, tree.Len())
+		}
+	}
+
+	if tree.Len() != len(expected) {
+		t.Fatalf("Len() = %d, want %d", tree.Len(), len(expected))
+	}
+
+	keys := make([]int, 0, len(expected))
+	for k := range expected {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	var got []int
+	tree.Ascend(func(k, v int) bool {
+		if v != expected[k] {
+			t.Fatalf("Ascend() visited %d: %d, want %d", k, v, expected[k])
+		}
+		got = append(got, k)
+		return false
+	})
+	if !slices.Equal(got, keys) && len(keys) > 0 {
+		t.Fatalf("Ascend() visited %v, want %v", got, keys)
+	}
+}
+
+func TestPersistentBTree(t *testing.T) {
+	t.Run("Random", func(t *testing.T) {
+		tree := NewPersistentBTree[int, int]()
+		expected := make(map[int]int)
+
+		type version struct {
+			tree     *PersistentBTree[int, int]
+			expected map[int]int
+		}
+		var versions []version
+
+		for i := 0; i < 30000; i++ {
+			k := rand.Intn(3000)
+			if rand.Intn(3) == 0 {
+				_, want := expected[k]
+				if got := tree.Delete(k); got != want {
+					t.Fatalf("Delete(%d) = %v, want %v", k, got, want)
+				}
+				delete(expected, k)
+			} else {
+				tree.Set(k, i)
+				expected[k] = i
+			}
+
+			want, wantOk := expected[k]
+			if v, ok := tree.Get(k); v != want || ok != wantOk {
+				t.Fatalf("Get(%d) = %d, %v, want %d, %v", k, v, ok, want, wantOk)
+			}
+
+			if i%1000 == 999 {
+				validateTree(t, tree, expected)
+
+				snapshot := make(map[int]int, len(expected))
+				for k, v := range expected {
+					snapshot[k] = v
+				}
+				versions = append(versions, version{tree.Clone(), snapshot})
+			}
+		}
+
+		for _, v := range versions {
+			validateTree(t, v.tree, v.expected)
+		}
+
+		for k := range expected {
+			tree.Delete(k)
+		}
+		validateTree(t, tree, map[int]int{})
+		if tree.root != nil {
+			t.Errorf("the root of an empty tree should be nil")
+		}
+
+		for _, v := range versions {
+			validateTree(t, v.tree, v.expected)
+			v.tree.Destroy()
+		}
+	})
+
+	t.Run("CopyOnWrite", func(t *testing.T) {
+		tree := NewPersistentBTree[int, int]()
+		for i := 0; i < 10000; i++ {
+			tree.Set(i, i)
+		}
+
+		clone := tree.Clone()
+		clone.Set(5000, -1)
+
+		// only the path to the key is copied
+		copied := 0
+		var walk func(n1, n2 *node[int, int])
+		walk = func(n1, n2 *node[int, int]) {
+			if n1 == n2 {
+				return
+			}
+			copied++
+			for i := range n1.children {
+				walk(n1.children[i], n2.children[i])
+			}
+		}
+		walk(tree.root, clone.root)
+
+		if height, _ := validateNode(t, tree, tree.root, true); copied != height+1 {
+			t.Errorf("%d nodes were copied, want %d", copied, height+1)
+		}
+		if v, _ := tree.Get(5000); v != 5000 {
+			t.Errorf("changing a clone should not change the original")
+		}
+
+		clone.Destroy()
+		var check func(n *node[int, int])
+		check = func(n *node[int, int]) {
+			if n.refCount != 1 {
+				t.Fatalf("node refCount = %d after destroying the clone, want 1", n.refCount)
+			}
+			for _, child := range n.children {
+				check(child)
+			}
+		}
+		check(tree.root)
+	})
+}
+
+func TestPersistentBTreeQueries(t *testing.T) {
+	tree := NewPersistentBTree[int, string]()
+	if _, _, ok := tree.Min(); ok {
+		t.Errorf("Min() of an empty tree should not be found")
+	}
+	if _, _, ok := tree.Floor(1); ok {
+		t.Errorf("Floor() of an empty tree should not be found")
+	}
+
+	// even keys from 0 to 1998
+	for i := 0; i < 1000; i++ {
+		tree.Set(i*2, strings.Repeat("x", i%3))
+	}
+
+	if k, _, ok := tree.Min(); !ok || k != 0 {
+		t.Errorf("Min() = %d, want 0", k)
+	}
+	if k, _, ok := tree.Max(); !ok || k != 1998 {
+		t.Errorf("Max() = %d, want 1998", k)
+	}
+
+	for k := -1; k <= 2000; k++ {
+		wantFloor, floorOk := k-k%2, k >= 0
+		if k > 1998 {
+			wantFloor = 1998
+		}
+		if got, _, ok := tree.Floor(k); ok != floorOk || (ok && got != wantFloor) {
+			t.Fatalf("Floor(%d) = %d, %v, want %d, %v", k, got, ok, wantFloor, floorOk)
+		}
+
+		wantCeiling, ceilingOk := k+(k&1), k <= 1998
+		if k < 0 {
+			wantCeiling = 0
+		}
+		if got, _, ok := tree.Ceiling(k); ok != ceilingOk || (ok && got != wantCeiling) {
+			t.Fatalf("Ceiling(%d) = %d, %v, want %d, %v", k, got, ok, wantCeiling, ceilingOk)
+		}
+	}
+
+	collect := func(iter func(f func(k int, v string) bool)) []int {
+		var keys []int
+		iter(func(k int, v string) bool {
+			keys = append(keys, k)
+			return false
+		})
+		return keys
+	}
+
+	for _, r := range [][2]int{{-10, 5}, {3, 9}, {4, 10}, {500, 1700}, {1990, 3000}, {7, 7}, {8, 3}} {
+		from, to := r[0], r[1]
+		var want []int
+		for k := 0; k < 2000; k += 2 {
+			if k >= from && k < to {
+				want = append(want, k)
+			}
+		}
+
+		got := collect(func(f func(k int, v string) bool) { tree.Range(from, to, f) })
+		if !slices.Equal(got, want) {
+			t.Errorf("Range(%d, %d) = %v, want %v", from, to, got, want)
+		}
+
+		for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+			want[i], want[j] = want[j], want[i]
+		}
+		got = collect(func(f func(k int, v string) bool) { tree.RangeReverse(from, to, f) })
+		if !slices.Equal(got, want) {
+			t.Errorf("RangeReverse(%d, %d) = %v, want %v", from, to, got, want)
+		}
+	}
+
+	all := collect(tree.Descend)
+	if len(all) != 1000 || all[0] != 1998 || all[999] != 0 || !sort.SliceIsSorted(all, func(i, j int) bool { return all[i] > all[j] }) {
+		t.Errorf("Descend() should visit every key in decreasing order")
+	}
+
+	count := 0
+	tree.Ascend(func(k int, v string) bool {
+		count++
+		return count == 10
+	})
+	if count != 10 {
+		t.Errorf("Ascend() should stop when f returns true")
+	}
+}
+
+func TestPersistentBTreeFunc(t *testing.T) {
+	// case-insensitive keys, in reverse order
+	tree := NewPersistentBTreeFunc[string, int](func(a, b string) bool {
+		return strings.ToLower(a) > strings.ToLower(b)
+	})
+
+	for i, k := range []string{"b", "A", "c", "B", "d"} {
+		tree.Set(k, i)
+	}
+
+	if tree.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", tree.Len())
+	}
+	if v, ok := tree.Get("b"); !ok || v != 3 {
+		t.Errorf("Get(b) = %d, %v, want 3", v, ok)
+	}
+
+	var keys []string
+	tree.Ascend(func(k string, v int) bool {
+		keys = append(keys, k)
+		return false
+	})
+	// Set replaces the key along with the value
+	if !slices.Equal(keys, []string{"d", "c", "B", "A"}) {
+		t.Errorf("Ascend() = %v", keys)
+	}
+}
+
+func BenchmarkPersistentBTree(b *testing.B) {
+	b.Run("Set", func(b *testing.B) {
+		tree := NewPersistentBTree[int, int]()
+		for i := 0; i < b.N; i++ {
+			tree.Set(rand.Int(), i)
+		}
+	})
+
+	tree := NewPersistentBTree[int, int]()
+	for i := 0; i < 100000; i++ {
+		tree.Set(i, i)
+	}
+
+	b.Run("SetClone", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			clone := tree.Clone()
+			clone.Set(i%100000, -i)
+			clone.Destroy()
+		}
+	})
+
+	b.Run("Get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tree.Get(i % 100000)
+		}
+	})
+}
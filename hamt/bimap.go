@@ -0,0 +1,100 @@
+package hamt
+
+// BiMap is a persistent one-to-one map, which can be looked up by key as well as by value.
+// It is made of two PersistentHAMTs, one mapping the keys to the values and one mapping the values back to the keys,
+// which are always changed together so they stay consistent in every version.
+type BiMap[K, V comparable] struct {
+	forward  *PersistentHAMT[K, V]
+	backward *PersistentHAMT[V, K]
+}
+
+// NewBiMap returns an empty BiMap using hk to hash the keys and hv to hash the values.
+func NewBiMap[K, V comparable](hk Hasher[K], hv Hasher[V]) *BiMap[K, V] {
+	return &BiMap[K, V]{
+		forward:  NewPersistentHAMT[K, V](hk),
+		backward: NewPersistentHAMT[V, K](hv),
+	}
+}
+
+// Len returns the number of pairs of the map.
+func (m *BiMap[K, V]) Len() int {
+	return m.forward.Len()
+}
+
+// GetByKey returns the value paired with the key k and whether it was found.
+func (m *BiMap[K, V]) GetByKey(k K) (V, bool) {
+	return m.forward.Get(k)
+}
+
+// GetByValue returns the key paired with the value v and whether it was found.
+func (m *BiMap[K, V]) GetByValue(v V) (K, bool) {
+	return m.backward.Get(v)
+}
+
+// Put pairs the key k with the value v. The previous value of k and the previous key of v, if any, are unpaired
+// first, so both stay unique.
+func (m *BiMap[K, V]) Put(k K, v V) {
+	if old, ok := m.forward.Get(k); ok {
+		if old == v {
+			return
+		}
+		m.backward.Delete(old)
+	}
+
+	if old, ok := m.backward.Get(v); ok {
+		m.forward.Delete(old)
+	}
+
+	m.forward.Set(k, v)
+	m.backward.Set(v, k)
+}
+
+// DeleteByKey removes the key k with its value and reports whether it was found.
+func (m *BiMap[K, V]) DeleteByKey(k K) bool {
+	v, ok := m.forward.Get(k)
+	if !ok {
+		return false
+	}
+
+	m.forward.Delete(k)
+	m.backward.Delete(v)
+	return true
+}
+
+// DeleteByValue removes the value v with its key and reports whether it was found.
+func (m *BiMap[K, V]) DeleteByValue(v V) bool {
+	k, ok := m.backward.Get(v)
+	if !ok {
+		return false
+	}
+
+	m.backward.Delete(v)
+	m.forward.Delete(k)
+	return true
+}
+
+// Inverse returns a copy of the map with the keys and the values swapped, in O(1).
+func (m *BiMap[K, V]) Inverse() *BiMap[V, K] {
+	return &BiMap[V, K]{
+		forward:  m.backward.Clone(),
+		backward: m.forward.Clone(),
+	}
+}
+
+// Range calls f for every key-value pair of the map, in no particular order, until f returns true.
+func (m *BiMap[K, V]) Range(f func(k K, v V) bool) {
+	m.forward.Range(f)
+}
+
+// Clone returns a copy of the map in O(1). The two maps share their nodes until they are changed.
+func (m *BiMap[K, V]) Clone() *BiMap[K, V] {
+	return &BiMap[K, V]{
+		forward:  m.forward.Clone(),
+		backward: m.backward.Clone(),
+	}
+}
+
+func (m *BiMap[K, V]) Destroy() {
+	m.forward.Destroy()
+	m.backward.Destroy()
+}
@@ -0,0 +1,89 @@
+package hamt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func validateBiMap(t *testing.T, m *BiMap[int, string], expected map[int]string) {
+	t.Helper()
+
+	if m.Len() != len(expected) || m.backward.Len() != len(expected) {
+		t.Fatalf("Len() = %d, %d values, want %d", m.Len(), m.backward.Len(), len(expected))
+	}
+
+	for k, v := range expected {
+		if got, ok := m.GetByKey(k); !ok || got != v {
+			t.Fatalf("GetByKey(%d) = %q, %v, want %q", k, got, ok, v)
+		}
+		if got, ok := m.GetByValue(v); !ok || got != k {
+			t.Fatalf("GetByValue(%q) = %d, %v, want %d", v, got, ok, k)
+		}
+	}
+}
+
+func TestBiMap(t *testing.T) {
+	t.Run("Random", func(t *testing.T) {
+		m := NewBiMap[int, string](newIntHasher(), newHasher[string]())
+		expected := make(map[int]string)
+		names := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+
+		for i := 0; i < 5000; i++ {
+			k, v := rand.Intn(15), names[rand.Intn(len(names))]
+			switch rand.Intn(4) {
+			case 0, 1:
+				m.Put(k, v)
+				for k1, v1 := range expected {
+					if v1 == v {
+						delete(expected, k1)
+					}
+				}
+				expected[k] = v
+			case 2:
+				_, want := expected[k]
+				if got := m.DeleteByKey(k); got != want {
+					t.Fatalf("DeleteByKey(%d) = %v, want %v", k, got, want)
+				}
+				delete(expected, k)
+			default:
+				want := false
+				for k1, v1 := range expected {
+					if v1 == v {
+						delete(expected, k1)
+						want = true
+					}
+				}
+				if got := m.DeleteByValue(v); got != want {
+					t.Fatalf("DeleteByValue(%q) = %v, want %v", v, got, want)
+				}
+			}
+
+			validateBiMap(t, m, expected)
+		}
+	})
+
+	t.Run("Inverse", func(t *testing.T) {
+		m := NewBiMap[int, string](newIntHasher(), newHasher[string]())
+		m.Put(1, "one")
+		m.Put(2, "two")
+
+		inv := m.Inverse()
+		if k, ok := inv.GetByKey("two"); !ok || k != 2 {
+			t.Errorf("Inverse().GetByKey(two) = %d, %v", k, ok)
+		}
+
+		inv.Put("three", 3)
+		inv.DeleteByValue(1)
+		if m.Len() != 2 || inv.Len() != 2 {
+			t.Errorf("changing the inverse should not change the map")
+		}
+		if v, ok := inv.Inverse().GetByKey(3); !ok || v != "three" {
+			t.Errorf("Inverse().Inverse().GetByKey(3) = %q, %v", v, ok)
+		}
+
+		clone := m.Clone()
+		clone.Put(1, "two") // unpairs 1 from one and 2 from two
+		validateBiMap(t, clone, map[int]string{1: "two"})
+		validateBiMap(t, m, map[int]string{1: "one", 2: "two"})
+	})
+}
@@ -0,0 +1,224 @@
+package hamt
+
+// Equal reports whether a and b hold the same set of keys and eq reports true for the values of every key.
+// Both maps must hash their keys the same way, which is always the case for versions derived from each other.
+// Since the shape of the trie only depends on the keys it holds, the two tries are walked together and sub-nodes
+// or records shared by both versions are skipped without being visited, so comparing a map with a modified Clone
+// costs time in proportion to the changed region only.
+func Equal[K comparable, V any](a, b *PersistentHAMT[K, V], eq func(V, V) bool) bool {
+	if a == b {
+		return true
+	}
+
+	if a.Len() != b.Len() {
+		return false
+	}
+
+	return equalNode(a.root, b.root, eq)
+}
+
+func equalNode[K comparable, V any](n1, n2 *trieNode[K, *record[K, V]], eq func(V, V) bool) bool {
+	if n1 == n2 {
+		return true
+	}
+
+	if n1 == nil || n2 == nil {
+		// a nil root is the same as an empty one
+		return (n1 == nil || n1.isEmpty()) && (n2 == nil || n2.isEmpty())
+	}
+
+	if n1.dataMap != n2.dataMap || n1.nodeMap != n2.nodeMap {
+		return false
+	}
+
+	for i, r1 := range n1.entries {
+		r2 := n2.entries[i]
+		if r1 == r2 {
+			continue
+		}
+
+		if r1.key != r2.key || !eq(r1.value, r2.value) {
+			return false
+		}
+	}
+
+	for i, child := range n1.nodes {
+		if !equalNode(child, n2.nodes[i], eq) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ChangeKind tells how an entry differs between two versions of a map.
+type ChangeKind int
+
+const (
+	Added   ChangeKind = iota // the key only exists in the new version
+	Removed                   // the key only exists in the old version
+	Changed                   // the key exists in both versions with different values
+)
+
+func (c ChangeKind) String() string {
+	switch c {
+	case Added:
+		return "Added"
+	case Removed:
+		return "Removed"
+	case Changed:
+		return "Changed"
+	}
+	return "Unknown"
+}
+
+// Change describes an entry that differs between two versions of a map.
+// Old is the zero value for an Added entry and New is the zero value for a Removed entry.
+type Change[K comparable, V any] struct {
+	Kind ChangeKind
+	Key  K
+	Old  V
+	New  V
+}
+
+// Diff calls f for every entry that was added, removed or changed between old and new, in no particular order.
+// A key is reported as Changed when eq reports false for its two values. If f returns true, Diff stops.
+// Like Equal, both maps must hash their keys the same way. Sub-nodes and records shared by the two versions are
+// skipped, so Diff costs time in proportion to the edit and not to the size of the maps.
+func Diff[K comparable, V any](old, new *PersistentHAMT[K, V], eq func(V, V) bool, f func(c Change[K, V]) bool) {
+	if old == new {
+		return
+	}
+
+	old.thaw()
+	new.thaw()
+	diffNode(old.root, new.root, eq, f)
+}
+
+func diffNode[K comparable, V any](n1, n2 *trieNode[K, *record[K, V]], eq func(V, V) bool, f func(c Change[K, V]) bool) bool {
+	if n1 == n2 {
+		return false
+	}
+
+	if n1 == nil {
+		return reportAll(n2, Added, f)
+	}
+
+	if n2 == nil {
+		return reportAll(n1, Removed, f)
+	}
+
+	for used := n1.dataMap | n1.nodeMap | n2.dataMap | n2.nodeMap; used != 0; used &= used - 1 {
+		mask := used & -used
+
+		var stop bool
+		switch {
+		case n1.dataMap&mask != 0 && n2.dataMap&mask != 0:
+			stop = diffRecord(n1.entries[n1.entryIndex(mask)], n2.entries[n2.entryIndex(mask)], eq, f)
+		case n1.nodeMap&mask != 0 && n2.nodeMap&mask != 0:
+			stop = diffNode(n1.nodes[n1.nodeIndex(mask)], n2.nodes[n2.nodeIndex(mask)], eq, f)
+		case n1.dataMap&mask != 0 && n2.nodeMap&mask != 0:
+			stop = diffRecordNode(n1.entries[n1.entryIndex(mask)], n2.nodes[n2.nodeIndex(mask)], false, eq, f)
+		case n1.nodeMap&mask != 0 && n2.dataMap&mask != 0:
+			stop = diffRecordNode(n2.entries[n2.entryIndex(mask)], n1.nodes[n1.nodeIndex(mask)], true, eq, f)
+		case n1.dataMap&mask != 0:
+			r := n1.entries[n1.entryIndex(mask)]
+			stop = f(newChange(Removed, r.key, r.value))
+		case n1.nodeMap&mask != 0:
+			stop = reportAll(n1.nodes[n1.nodeIndex(mask)], Removed, f)
+		case n2.dataMap&mask != 0:
+			r := n2.entries[n2.entryIndex(mask)]
+			stop = f(newChange(Added, r.key, r.value))
+		default:
+			stop = reportAll(n2.nodes[n2.nodeIndex(mask)], Added, f)
+		}
+
+		if stop {
+			return true
+		}
+	}
+
+	return false
+}
+
+func diffRecord[K comparable, V any](r1, r2 *record[K, V], eq func(V, V) bool, f func(c Change[K, V]) bool) bool {
+	if r1 == r2 {
+		return false
+	}
+
+	if r1.key == r2.key {
+		if eq(r1.value, r2.value) {
+			return false
+		}
+		return f(Change[K, V]{Kind: Changed, Key: r1.key, Old: r1.value, New: r2.value})
+	}
+
+	if f(newChange(Removed, r1.key, r1.value)) {
+		return true
+	}
+	return f(newChange(Added, r2.key, r2.value))
+}
+
+// diffRecordNode compares the record r of one version with the sub-node n found in the same slot of the other version.
+// Every entry of n but the one with the key of r exists in a single version. If reversed is true, r belongs to the new
+// version and n to the old one.
+func diffRecordNode[K comparable, V any](r *record[K, V], n *trieNode[K, *record[K, V]], reversed bool, eq func(V, V) bool, f func(c Change[K, V]) bool) bool {
+	nodeKind, recordKind := Added, Removed
+	if reversed {
+		nodeKind, recordKind = Removed, Added
+	}
+
+	found := false
+	stop := rangeEntries(n, func(r1 *record[K, V]) bool {
+		if r1.key != r.key {
+			return f(newChange(nodeKind, r1.key, r1.value))
+		}
+
+		found = true
+		if reversed {
+			return diffRecord(r1, r, eq, f)
+		}
+		return diffRecord(r, r1, eq, f)
+	})
+
+	if stop || found {
+		return stop
+	}
+
+	return f(newChange(recordKind, r.key, r.value))
+}
+
+// reportAll reports every entry under n as a change of the given kind, which is either Added or Removed.
+func reportAll[K comparable, V any](n *trieNode[K, *record[K, V]], kind ChangeKind, f func(c Change[K, V]) bool) bool {
+	return rangeEntries(n, func(r *record[K, V]) bool {
+		return f(newChange(kind, r.key, r.value))
+	})
+}
+
+func newChange[K comparable, V any](kind ChangeKind, k K, v V) Change[K, V] {
+	if kind == Added {
+		return Change[K, V]{Kind: Added, Key: k, New: v}
+	}
+	return Change[K, V]{Kind: Removed, Key: k, Old: v}
+}
+
+// rangeEntries calls iter for every entry under n. If iter returns true, rangeEntries stops and returns true.
+func rangeEntries[K comparable, E entry[K, E]](n *trieNode[K, E], iter func(e E) bool) bool {
+	if n == nil {
+		return false
+	}
+
+	for _, e := range n.entries {
+		if iter(e) {
+			return true
+		}
+	}
+
+	for _, child := range n.nodes {
+		if rangeEntries(child, iter) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,217 @@
+package hamt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func intEq(v1, v2 int) bool {
+	return v1 == v2
+}
+
+func TestEqual(t *testing.T) {
+	t.Run("Basic Equal", func(t *testing.T) {
+		trie := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		other := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+
+		if !Equal(trie, other, intEq) {
+			t.Errorf("empty maps should be equal")
+		}
+
+		for i, k := range []string{"a", "b", "c", "d", "rehash2time_1", "rehash2time_2"} {
+			trie.Set(k, i)
+		}
+
+		for i, k := range []string{"rehash2time_2", "rehash2time_1", "d", "c", "b", "a"} {
+			other.Set(k, 5-i)
+		}
+
+		if !Equal(trie, other, intEq) {
+			t.Errorf("maps with the same entries inserted in a different order should be equal")
+		}
+
+		other.Set("a", 100)
+		if Equal(trie, other, intEq) {
+			t.Errorf("maps with a different value should not be equal")
+		}
+
+		if !Equal(trie, other, func(v1, v2 int) bool { return true }) {
+			t.Errorf("eq should decide whether the values are equal")
+		}
+
+		other.Set("a", 0)
+		other.Delete("d")
+		other.Set("e", 3)
+		if Equal(trie, other, intEq) {
+			t.Errorf("maps with different keys should not be equal")
+		}
+
+		other.Delete("e")
+		other.Set("d", 3)
+		if !Equal(trie, other, intEq) {
+			t.Errorf("maps should be equal again")
+		}
+
+		trie.Destroy()
+		other.Destroy()
+		if !Equal(trie, NewPersistentHAMT[string, int](newCollisionHasher[string]()), intEq) {
+			t.Errorf("a destroyed map should be equal to an empty map")
+		}
+	})
+
+	t.Run("Equal with Clone", func(t *testing.T) {
+		trie := NewPersistentHAMT[int, int](newIntHasher())
+		for i := 0; i < 10000; i++ {
+			trie.Set(i, i)
+		}
+
+		clone := trie.Clone()
+		calls := 0
+		countingEq := func(v1, v2 int) bool {
+			calls++
+			return v1 == v2
+		}
+
+		if !Equal(trie, clone, countingEq) || calls != 0 {
+			t.Errorf("a map and its clone should be equal without comparing values, compared %d values", calls)
+		}
+
+		clone.Set(42, 42)
+		if !Equal(trie, clone, countingEq) {
+			t.Errorf("re-setting the same value should keep the maps equal")
+		}
+
+		if calls != 1 {
+			t.Errorf("only the changed record should be compared, compared %d values", calls)
+		}
+
+		clone.Delete(7)
+		if Equal(trie, clone, intEq) || Equal(clone, trie, intEq) {
+			t.Errorf("maps with different length should not be equal")
+		}
+
+		clone.Set(7, 7)
+		if !Equal(trie, clone, intEq) {
+			t.Errorf("deleting and re-inserting a key should give an equal map")
+		}
+	})
+}
+
+func collectDiff[K comparable, V any](old, new *PersistentHAMT[K, V], eq func(V, V) bool) map[K]Change[K, V] {
+	changes := make(map[K]Change[K, V])
+	Diff(old, new, eq, func(c Change[K, V]) bool {
+		changes[c.Key] = c
+		return false
+	})
+	return changes
+}
+
+func expectedDiff[K comparable, V comparable](old, new map[K]V) map[K]Change[K, V] {
+	changes := make(map[K]Change[K, V])
+	for k, v := range old {
+		if v2, ok := new[k]; !ok {
+			changes[k] = Change[K, V]{Kind: Removed, Key: k, Old: v}
+		} else if v != v2 {
+			changes[k] = Change[K, V]{Kind: Changed, Key: k, Old: v, New: v2}
+		}
+	}
+
+	for k, v := range new {
+		if _, ok := old[k]; !ok {
+			changes[k] = Change[K, V]{Kind: Added, Key: k, New: v}
+		}
+	}
+	return changes
+}
+
+func TestDiff(t *testing.T) {
+	t.Run("Basic Diff", func(t *testing.T) {
+		old := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		old.Set("a", 1)
+		old.Set("c", 3)
+		old.Set("rehash2time_1", 5)
+
+		new := old.Clone()
+		new.Set("a", 10)            // changed
+		new.Set("d", 4)             // added, collides with c
+		new.Delete("rehash2time_1") // removed
+		new.Set("rehash2time_2", 6) // added
+		new.Set("b", 2)             // added, collides with a
+
+		assertSameMap(t, collectDiff(old, new, intEq), map[string]Change[string, int]{
+			"a":             {Kind: Changed, Key: "a", Old: 1, New: 10},
+			"b":             {Kind: Added, Key: "b", New: 2},
+			"d":             {Kind: Added, Key: "d", New: 4},
+			"rehash2time_1": {Kind: Removed, Key: "rehash2time_1", Old: 5},
+			"rehash2time_2": {Kind: Added, Key: "rehash2time_2", New: 6},
+		})
+
+		assertSameMap(t, collectDiff(new, old, intEq), map[string]Change[string, int]{
+			"a":             {Kind: Changed, Key: "a", Old: 10, New: 1},
+			"b":             {Kind: Removed, Key: "b", Old: 2},
+			"d":             {Kind: Removed, Key: "d", Old: 4},
+			"rehash2time_1": {Kind: Added, Key: "rehash2time_1", New: 5},
+			"rehash2time_2": {Kind: Removed, Key: "rehash2time_2", Old: 6},
+		})
+
+		if changes := collectDiff(old, old.Clone(), intEq); len(changes) != 0 {
+			t.Errorf("a map and its clone should have no difference, got %v", changes)
+		}
+
+		count := 0
+		Diff(old, new, intEq, func(c Change[string, int]) bool {
+			count++
+			return true
+		})
+		if count != 1 {
+			t.Errorf("Diff should stop when f returns true, called %d times", count)
+		}
+
+		new.Destroy()
+		assertSameMap(t, collectDiff(old, new, intEq), map[string]Change[string, int]{
+			"a":             {Kind: Removed, Key: "a", Old: 1},
+			"c":             {Kind: Removed, Key: "c", Old: 3},
+			"rehash2time_1": {Kind: Removed, Key: "rehash2time_1", Old: 5},
+		})
+	})
+
+	t.Run("Random Diff", func(t *testing.T) {
+		old := NewPersistentHAMT[int, int](newIntHasher())
+		oldExpected := make(map[int]int)
+		for i := 0; i < 10000; i++ {
+			old.Set(i, i)
+			oldExpected[i] = i
+		}
+
+		new := old.Clone()
+		newExpected := make(map[int]int)
+		for k, v := range oldExpected {
+			newExpected[k] = v
+		}
+
+		for i := 0; i < 300; i++ {
+			k := rand.Intn(12000)
+			switch rand.Intn(3) {
+			case 0:
+				new.Delete(k)
+				delete(newExpected, k)
+			default:
+				new.Set(k, -k)
+				newExpected[k] = -k
+			}
+		}
+
+		calls := 0
+		countingEq := func(v1, v2 int) bool {
+			calls++
+			return v1 == v2
+		}
+
+		changes := collectDiff(old, new, countingEq)
+		assertSameMap(t, changes, expectedDiff(oldExpected, newExpected))
+
+		if calls > 300 {
+			t.Errorf("Diff should only compare values in the changed region, compared %d values", calls)
+		}
+	})
+}
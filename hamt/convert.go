@@ -0,0 +1,138 @@
+package hamt
+
+// Entry is a key-value pair of a map.
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// hashedRecord is a record waiting to be placed by the batch builder, with the hash of its key for the current depth.
+type hashedRecord[K comparable, V any] struct {
+	hash uint64
+	r    *record[K, V]
+}
+
+// FromMap returns a new map holding the entries of the Go map src.
+// Instead of inserting the entries one by one, the trie is built level by level from the hashed keys, so every node
+// is allocated once with its final size and nothing is copied.
+func FromMap[K comparable, V any](h Hasher[K], src map[K]V) *PersistentHAMT[K, V] {
+	if len(src) == 0 {
+		return NewPersistentHAMT[K, V](h)
+	}
+	m := &PersistentHAMT[K, V]{}
+	m.hasher = h
+
+	entries := make([]hashedRecord[K, V], 0, len(src))
+	for k, v := range src {
+		entries = append(entries, hashedRecord[K, V]{hash: m.hash(k, 0), r: newRecord[K, V](k, v, nil)})
+	}
+
+	m.root = m.build(entries, make([]hashedRecord[K, V], len(entries)), 0)
+	m.len = len(src)
+	return m
+}
+
+// build creates the node holding the given entries at the given depth. The keys must be distinct.
+// buf is a scratch buffer at least as long as entries; both are reordered.
+func (m *PersistentHAMT[K, V]) build(entries []hashedRecord[K, V], buf []hashedRecord[K, V], depth int) *trieNode[K, *record[K, V]] {
+
+	level := depth % (exhaustedLevel + 1)
+	shift := level * arity
+
+	var counts [childPerNode]int
+	for _, e := range entries {
+		counts[bucket(e.hash, shift)]++
+	}
+
+	n := newMapNodeWithRef[K, V]()
+	n.size = len(entries)
+
+	var offsets [childPerNode]int
+	offset, recordCount, nodeCount := 0, 0, 0
+	for loc, count := range counts {
+		offsets[loc] = offset
+		offset += count
+
+		switch {
+		case count == 1:
+			n.dataMap |= 1 << loc
+			recordCount++
+		case count > 1:
+			n.nodeMap |= 1 << loc
+			nodeCount++
+		}
+	}
+
+	// group the entries by bucket
+	buf = buf[:len(entries)]
+	for _, e := range entries {
+		loc := bucket(e.hash, shift)
+		buf[offsets[loc]] = e
+		offsets[loc]++
+	}
+
+	n.entries = make([]*record[K, V], 0, recordCount)
+	n.nodes = make([]*trieNode[K, *record[K, V]], 0, nodeCount)
+
+	start := 0
+	for _, count := range counts {
+		group := buf[start : start+count]
+		start += count
+
+		switch {
+		case count == 1:
+			n.entries = append(n.entries, group[0].r)
+		case count > 1:
+			if level == exhaustedLevel {
+				for i := range group {
+					group[i].hash = m.hash(group[i].r.key, depth+1)
+				}
+			}
+
+			// entries now holds stale data and can serve as the scratch buffer of the group
+			n.nodes = append(n.nodes, m.build(group, entries[start-count:start], depth+1))
+		}
+	}
+
+	return n
+}
+
+// ToMap returns a Go map holding the entries of the map.
+func (m *PersistentHAMT[K, V]) ToMap() map[K]V {
+	result := make(map[K]V, m.Len())
+	m.Range(func(k K, v V) bool {
+		result[k] = v
+		return false
+	})
+	return result
+}
+
+// Keys returns the keys of the map in no particular order.
+func (m *PersistentHAMT[K, V]) Keys() []K {
+	keys := make([]K, 0, m.Len())
+	m.Range(func(k K, v V) bool {
+		keys = append(keys, k)
+		return false
+	})
+	return keys
+}
+
+// Values returns the values of the map in the same order as Keys.
+func (m *PersistentHAMT[K, V]) Values() []V {
+	values := make([]V, 0, m.Len())
+	m.Range(func(k K, v V) bool {
+		values = append(values, v)
+		return false
+	})
+	return values
+}
+
+// Entries returns the key-value pairs of the map in the same order as Keys.
+func (m *PersistentHAMT[K, V]) Entries() []Entry[K, V] {
+	entries := make([]Entry[K, V], 0, m.Len())
+	m.Range(func(k K, v V) bool {
+		entries = append(entries, Entry[K, V]{Key: k, Value: v})
+		return false
+	})
+	return entries
+}
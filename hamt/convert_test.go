@@ -0,0 +1,120 @@
+package hamt
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestFromMap(t *testing.T) {
+	t.Run("Collision", func(t *testing.T) {
+		src := make(map[string]int)
+		for i, k := range []string{"a", "b", "c", "d", "e", "rehash2time_1", "rehash2time_2", "fullhash_1", "fullhash_2", "fullhash_1_collision"} {
+			src[k] = i
+		}
+
+		m := FromMap[string, int](newCollisionHasher[string](), src)
+		expected := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		for k, v := range src {
+			expected.Set(k, v)
+		}
+
+		if m.Len() != len(src) || !Equal(m, expected, intEq) || !sameShape(t, m.root, expected.root) {
+			t.Errorf("FromMap() = %v, want %v", m.ToMap(), src)
+		}
+
+		if !panics(func() { FromMap[string, int](newCollisionHasher[string](), map[string]int{"panic1": 1, "panic2": 2}) }) {
+			t.Errorf("FromMap() should panic when the hash function is exhausted")
+		}
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		src := make(map[int]int)
+		for i := 0; i < 10000; i++ {
+			src[i*7] = i
+		}
+
+		m := FromMap[int, int](newIntHasher(), src)
+		validateNode(t, m.root)
+		assertSameMap(t, m.ToMap(), src)
+
+		if !sameShape(t, m.root, buildMap(src).root) {
+			t.Errorf("FromMap() should build the same shape as insertions")
+		}
+
+		clone := m.Clone()
+		clone.Set(1, 1)
+		clone.Delete(0)
+		if _, ok := m.Get(1); ok || m.Len() != len(src) {
+			t.Errorf("modifying a clone should not change the map built by FromMap")
+		}
+
+		empty := FromMap[int, int](newIntHasher(), nil)
+		if empty.Len() != 0 || len(empty.ToMap()) != 0 {
+			t.Errorf("FromMap(nil) should return an empty map")
+		}
+		empty.Set(1, 1)
+		if v, ok := empty.Get(1); !ok || v != 1 {
+			t.Errorf("empty.Get(1) = %d, want %d", v, 1)
+		}
+	})
+}
+
+func TestConversions(t *testing.T) {
+	m := NewPersistentHAMT[int, int](newIntHasher())
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i*2)
+	}
+
+	keys := m.Keys()
+	values := m.Values()
+	entries := m.Entries()
+
+	if len(keys) != 1000 || len(values) != 1000 || len(entries) != 1000 {
+		t.Fatalf("len(Keys()) = %d, len(Values()) = %d, len(Entries()) = %d, want %d", len(keys), len(values), len(entries), 1000)
+	}
+
+	for i, k := range keys {
+		if values[i] != k*2 || entries[i].Key != k || entries[i].Value != k*2 {
+			t.Errorf("Keys, Values and Entries should be in the same order, got %d, %d, %v", k, values[i], entries[i])
+		}
+	}
+
+	slices.Sort(keys)
+	for i, k := range keys {
+		if k != i {
+			t.Fatalf("Keys()[%d] = %d, want %d", i, k, i)
+		}
+	}
+
+	if got := m.ToMap(); len(got) != 1000 || got[500] != 1000 {
+		t.Errorf("ToMap() = %v", got)
+	}
+}
+
+func BenchmarkFromMap(b *testing.B) {
+	for _, size := range benchSizes {
+		src := make(map[int]int, size)
+		for i := 0; i < size; i++ {
+			src[i] = i
+		}
+
+		b.Run(fmt.Sprintf("FromMap/%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				FromMap[int, int](newIntHasher(), src)
+			}
+		})
+
+		b.Run(fmt.Sprintf("Set/%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m := NewPersistentHAMT[int, int](newIntHasher())
+				for k, v := range src {
+					m.Set(k, v)
+				}
+			}
+		})
+	}
+}
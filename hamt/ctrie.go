@@ -0,0 +1,707 @@
+package hamt
+
+import (
+	"math/bits"
+	"sync/atomic"
+
+	"github.com/nnhatnam/immutable/slice"
+)
+
+// Ctrie is a concurrent hash trie, a mutable map that is safe for concurrent use by multiple goroutines without
+// locks (Prokopec et al., "Concurrent Tries with Efficient Non-Blocking Snapshots", 2012).
+//
+// The trie has the same shape as the one of PersistentHAMT and hashes keys with the same Hasher, but every sub-node
+// is reached through an indirection node (iNode) holding an immutable main node. A write copies the main node it
+// changes and publishes the copy with a compare-and-swap on the indirection node, so writers only contend when they
+// change the same node. Removed keys may leave tombs behind, which are compressed into their parent by the next
+// operation that meets them.
+//
+// A Ctrie must be created with NewCtrie.
+type Ctrie[K comparable, V any] struct {
+	root     atomic.Pointer[iNode[K, V]]
+	readOnly bool
+	size     atomic.Int64 // number of keys, unused in read-only snapshots
+
+	hasher Hasher[K]
+}
+
+// generation tags the nodes that belong to a version of the trie. A snapshot starts a new generation, and a node of
+// an older generation is copied before being changed, so the snapshot keeps seeing the old one.
+// The field gives the type a non-zero size, so every generation has a distinct address.
+type generation struct {
+	_ byte
+}
+
+// iNode is an indirection node. Its main node is changed with gcas only.
+type iNode[K comparable, V any] struct {
+	main atomic.Pointer[mainNode[K, V]]
+	gen  *generation
+
+	rdcss *rdcssDescriptor[K, V] // only set on a temporary root while a snapshot is taken
+}
+
+// mainNode holds either a cNode or, below the root, a tomb: the last leaf of a node emptied by a removal.
+// prev links to the previous main node while the gcas that installed the node is not complete; failed is only set on
+// the node marking a gcas that must be rolled back.
+type mainNode[K comparable, V any] struct {
+	cNode  *cNode[K, V]
+	tomb   *leaf[K, V]
+	failed *mainNode[K, V]
+
+	prev atomic.Pointer[mainNode[K, V]]
+}
+
+// cNode is an immutable branching node, laid out like trieNode: dataMap marks the slots holding a leaf, nodeMap the slots
+// holding an indirection node to a sub-trie.
+type cNode[K comparable, V any] struct {
+	dataMap uint64
+	nodeMap uint64
+
+	leaves []*leaf[K, V]
+	nodes  []*iNode[K, V]
+}
+
+type leaf[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+type rdcssDescriptor[K comparable, V any] struct {
+	old       *iNode[K, V]
+	expected  *mainNode[K, V]
+	nv        *iNode[K, V]
+	committed atomic.Bool
+}
+
+// NewCtrie returns an empty Ctrie using h to hash the keys.
+func NewCtrie[K comparable, V any](h Hasher[K]) *Ctrie[K, V] {
+	c := &Ctrie[K, V]{hasher: h}
+	gen := &generation{}
+	c.root.Store(newINode(&mainNode[K, V]{cNode: &cNode[K, V]{}}, gen))
+	return c
+}
+
+func newINode[K comparable, V any](main *mainNode[K, V], gen *generation) *iNode[K, V] {
+	i := &iNode[K, V]{gen: gen}
+	i.main.Store(main)
+	return i
+}
+
+// copyToGen returns a copy of the indirection node in the generation gen, pointing to the same main node.
+func (c *Ctrie[K, V]) copyToGen(i *iNode[K, V], gen *generation) *iNode[K, V] {
+	return newINode(c.gcasRead(i), gen)
+}
+
+func (cn *cNode[K, V]) leafIndex(mask uint64) int {
+	return bits.OnesCount64(cn.dataMap & (mask - 1))
+}
+
+func (cn *cNode[K, V]) nodeIndex(mask uint64) int {
+	return bits.OnesCount64(cn.nodeMap & (mask - 1))
+}
+
+// appendLeaf and appendNode add an entry in the slot of mask to a node under construction, in increasing slot order.
+func (cn *cNode[K, V]) appendLeaf(mask uint64, l *leaf[K, V]) {
+	cn.dataMap |= mask
+	cn.leaves = append(cn.leaves, l)
+}
+
+func (cn *cNode[K, V]) appendNode(mask uint64, i *iNode[K, V]) {
+	cn.nodeMap |= mask
+	cn.nodes = append(cn.nodes, i)
+}
+
+// The following methods return an updated copy of the node.
+
+func (cn *cNode[K, V]) insertedLeaf(mask uint64, l *leaf[K, V]) *cNode[K, V] {
+	return &cNode[K, V]{
+		dataMap: cn.dataMap | mask,
+		nodeMap: cn.nodeMap,
+		leaves:  slice.Insert(cn.leaves, cn.leafIndex(mask), l),
+		nodes:   cn.nodes,
+	}
+}
+
+func (cn *cNode[K, V]) updatedLeaf(mask uint64, l *leaf[K, V]) *cNode[K, V] {
+	return &cNode[K, V]{
+		dataMap: cn.dataMap,
+		nodeMap: cn.nodeMap,
+		leaves:  slice.Set(cn.leaves, cn.leafIndex(mask), l),
+		nodes:   cn.nodes,
+	}
+}
+
+func (cn *cNode[K, V]) removedLeaf(mask uint64) *cNode[K, V] {
+	return &cNode[K, V]{
+		dataMap: cn.dataMap &^ mask,
+		nodeMap: cn.nodeMap,
+		leaves:  slice.RemoveAt(cn.leaves, cn.leafIndex(mask)),
+		nodes:   cn.nodes,
+	}
+}
+
+// leafToNode replaces the leaf in the slot of mask with the indirection node i.
+func (cn *cNode[K, V]) leafToNode(mask uint64, i *iNode[K, V]) *cNode[K, V] {
+	return &cNode[K, V]{
+		dataMap: cn.dataMap &^ mask,
+		nodeMap: cn.nodeMap | mask,
+		leaves:  slice.RemoveAt(cn.leaves, cn.leafIndex(mask)),
+		nodes:   slice.Insert(cn.nodes, cn.nodeIndex(mask), i),
+	}
+}
+
+// nodeToLeaf replaces the indirection node in the slot of mask with the leaf l.
+func (cn *cNode[K, V]) nodeToLeaf(mask uint64, l *leaf[K, V]) *cNode[K, V] {
+	return &cNode[K, V]{
+		dataMap: cn.dataMap | mask,
+		nodeMap: cn.nodeMap &^ mask,
+		leaves:  slice.Insert(cn.leaves, cn.leafIndex(mask), l),
+		nodes:   slice.RemoveAt(cn.nodes, cn.nodeIndex(mask)),
+	}
+}
+
+// renewed copies the node and its indirection nodes to the generation gen.
+func (c *Ctrie[K, V]) renewed(cn *cNode[K, V], gen *generation) *cNode[K, V] {
+	nodes := make([]*iNode[K, V], len(cn.nodes))
+	for idx, i := range cn.nodes {
+		nodes[idx] = c.copyToGen(i, gen)
+	}
+
+	return &cNode[K, V]{
+		dataMap: cn.dataMap,
+		nodeMap: cn.nodeMap,
+		leaves:  cn.leaves,
+		nodes:   nodes,
+	}
+}
+
+// toContracted entombs a node below the root holding a single leaf, so its parent can inline the leaf.
+func (c *Ctrie[K, V]) toContracted(cn *cNode[K, V], depth int) *mainNode[K, V] {
+	if depth > 0 && cn.nodeMap == 0 && len(cn.leaves) == 1 {
+		return &mainNode[K, V]{tomb: cn.leaves[0]}
+	}
+	return &mainNode[K, V]{cNode: cn}
+}
+
+// toCompressed replaces the entombed sub-nodes of cn with their leaf.
+func (c *Ctrie[K, V]) toCompressed(cn *cNode[K, V], depth int) *mainNode[K, V] {
+	ncn := &cNode[K, V]{}
+
+	for used := cn.dataMap | cn.nodeMap; used != 0; used &= used - 1 {
+		var mask uint64 = 1 << bits.TrailingZeros64(used)
+
+		if cn.dataMap&mask != 0 {
+			ncn.appendLeaf(mask, cn.leaves[cn.leafIndex(mask)])
+			continue
+		}
+
+		i := cn.nodes[cn.nodeIndex(mask)]
+		if main := c.gcasRead(i); main.tomb != nil {
+			ncn.appendLeaf(mask, main.tomb)
+		} else {
+			ncn.appendNode(mask, i)
+		}
+	}
+
+	return c.toContracted(ncn, depth)
+}
+
+// gcas is a generation compare-and-swap: it replaces the main node old of i with n, unless a snapshot started a new
+// generation in the meantime.
+func (c *Ctrie[K, V]) gcas(i *iNode[K, V], old, n *mainNode[K, V]) bool {
+	n.prev.Store(old)
+	if i.main.CompareAndSwap(old, n) {
+		c.gcasComplete(i, n)
+		return n.prev.Load() == nil
+	}
+	return false
+}
+
+// gcasRead returns the main node of i, completing the pending gcas if any.
+func (c *Ctrie[K, V]) gcasRead(i *iNode[K, V]) *mainNode[K, V] {
+	main := i.main.Load()
+	if main.prev.Load() == nil {
+		return main
+	}
+	return c.gcasComplete(i, main)
+}
+
+// gcasComplete commits the pending gcas that installed main in i, or rolls it back if the generation of the root
+// changed, and returns the resulting main node.
+func (c *Ctrie[K, V]) gcasComplete(i *iNode[K, V], main *mainNode[K, V]) *mainNode[K, V] {
+	for {
+		prev := main.prev.Load()
+		root := c.rdcssReadRoot(true)
+		if prev == nil {
+			return main
+		}
+
+		if prev.failed != nil {
+			// the gcas failed, put the previous main node back
+			if i.main.CompareAndSwap(main, prev.failed) {
+				return prev.failed
+			}
+			main = i.main.Load()
+			continue
+		}
+
+		if root.gen == i.gen && !c.readOnly {
+			if main.prev.CompareAndSwap(prev, nil) {
+				return main
+			}
+			continue
+		}
+
+		main.prev.CompareAndSwap(prev, &mainNode[K, V]{failed: prev})
+		main = i.main.Load()
+	}
+}
+
+// rdcssRoot replaces the root old with nv if the main node of old is still expected.
+func (c *Ctrie[K, V]) rdcssRoot(old *iNode[K, V], expected *mainNode[K, V], nv *iNode[K, V]) bool {
+	desc := &iNode[K, V]{rdcss: &rdcssDescriptor[K, V]{old: old, expected: expected, nv: nv}}
+	if c.root.CompareAndSwap(old, desc) {
+		c.rdcssComplete(false)
+		return desc.rdcss.committed.Load()
+	}
+	return false
+}
+
+func (c *Ctrie[K, V]) readRoot() *iNode[K, V] {
+	return c.rdcssReadRoot(false)
+}
+
+func (c *Ctrie[K, V]) rdcssReadRoot(abort bool) *iNode[K, V] {
+	root := c.root.Load()
+	if root.rdcss != nil {
+		return c.rdcssComplete(abort)
+	}
+	return root
+}
+
+func (c *Ctrie[K, V]) rdcssComplete(abort bool) *iNode[K, V] {
+	for {
+		root := c.root.Load()
+		if root.rdcss == nil {
+			return root
+		}
+
+		desc := root.rdcss
+		if abort {
+			if c.root.CompareAndSwap(root, desc.old) {
+				return desc.old
+			}
+			continue
+		}
+
+		if c.gcasRead(desc.old) == desc.expected {
+			if c.root.CompareAndSwap(root, desc.nv) {
+				desc.committed.Store(true)
+				return desc.nv
+			}
+			continue
+		}
+
+		if c.root.CompareAndSwap(root, desc.old) {
+			return desc.old
+		}
+	}
+}
+
+// nextHash returns the hash of the key for depth+1, given its hash for depth.
+func (c *Ctrie[K, V]) nextHash(k K, keyHash uint64, depth int) uint64 {
+	if depth%(exhaustedLevel+1) == exhaustedLevel {
+		return hashAt(c.hasher, k, depth+1)
+	}
+	return keyHash
+}
+
+func slotMask(keyHash uint64, depth int) uint64 {
+	level := depth % (exhaustedLevel + 1)
+	return 1 << bucket(keyHash, level*arity)
+}
+
+// Load returns the value of the key k and whether it was found.
+func (c *Ctrie[K, V]) Load(k K) (V, bool) {
+	keyHash := c.hasher.Hash(k)
+	for {
+		root := c.readRoot()
+		if v, ok, retry := c.ilookup(root, k, keyHash, 0, nil, root.gen); !retry {
+			return v, ok
+		}
+	}
+}
+
+func (c *Ctrie[K, V]) ilookup(i *iNode[K, V], k K, keyHash uint64, depth int, parent *iNode[K, V], startGen *generation) (v V, ok, retry bool) {
+	main := c.gcasRead(i)
+	if main.tomb != nil {
+		if c.readOnly {
+			if main.tomb.key == k {
+				return main.tomb.value, true, false
+			}
+			return v, false, false
+		}
+
+		c.clean(parent, depth-1)
+		return v, false, true
+	}
+
+	cn := main.cNode
+	mask := slotMask(keyHash, depth)
+
+	switch {
+	case cn.dataMap&mask != 0:
+		if l := cn.leaves[cn.leafIndex(mask)]; l.key == k {
+			return l.value, true, false
+		}
+	case cn.nodeMap&mask != 0:
+		child := cn.nodes[cn.nodeIndex(mask)]
+		if c.readOnly || child.gen == startGen {
+			return c.ilookup(child, k, c.nextHash(k, keyHash, depth), depth+1, i, startGen)
+		}
+
+		if c.gcas(i, main, &mainNode[K, V]{cNode: c.renewed(cn, startGen)}) {
+			return c.ilookup(i, k, keyHash, depth, parent, startGen)
+		}
+		return v, false, true
+	}
+
+	return v, false, false
+}
+
+// Store sets the value of the key k to v.
+func (c *Ctrie[K, V]) Store(k K, v V) {
+	c.insert(&leaf[K, V]{key: k, value: v}, false)
+}
+
+// LoadOrStore returns the value of the key k if it exists. Otherwise, it stores and returns v.
+// loaded reports whether the value was found.
+func (c *Ctrie[K, V]) LoadOrStore(k K, v V) (actual V, loaded bool) {
+	if actual, loaded = c.insert(&leaf[K, V]{key: k, value: v}, true); loaded {
+		return actual, true
+	}
+	return v, false
+}
+
+func (c *Ctrie[K, V]) insert(l *leaf[K, V], onlyIfAbsent bool) (old V, loaded bool) {
+	c.checkWritable()
+	keyHash := c.hasher.Hash(l.key)
+	for {
+		root := c.readRoot()
+		if old, loaded, retry := c.iinsert(root, l, keyHash, 0, nil, root.gen, onlyIfAbsent); !retry {
+			if !loaded {
+				c.size.Add(1)
+			}
+			return old, loaded
+		}
+	}
+}
+
+// iinsert stores the leaf l under i, unless onlyIfAbsent is set and the key exists. It returns the previous value of
+// the key if it existed.
+func (c *Ctrie[K, V]) iinsert(i *iNode[K, V], l *leaf[K, V], keyHash uint64, depth int, parent *iNode[K, V], startGen *generation, onlyIfAbsent bool) (old V, loaded, retry bool) {
+	main := c.gcasRead(i)
+	if main.tomb != nil {
+		c.clean(parent, depth-1)
+		return old, false, true
+	}
+
+	cn := main.cNode
+	mask := slotMask(keyHash, depth)
+
+	var ncn *cNode[K, V]
+
+	switch {
+	case cn.nodeMap&mask != 0:
+		child := cn.nodes[cn.nodeIndex(mask)]
+		if child.gen == startGen {
+			return c.iinsert(child, l, c.nextHash(l.key, keyHash, depth), depth+1, i, startGen, onlyIfAbsent)
+		}
+
+		if c.gcas(i, main, &mainNode[K, V]{cNode: c.renewed(cn, startGen)}) {
+			return c.iinsert(i, l, keyHash, depth, parent, startGen, onlyIfAbsent)
+		}
+		return old, false, true
+
+	case cn.dataMap&mask != 0:
+		colLeaf := cn.leaves[cn.leafIndex(mask)]
+
+		if colLeaf.key == l.key {
+			if onlyIfAbsent {
+				return colLeaf.value, true, false
+			}
+			old, loaded = colLeaf.value, true
+			ncn = cn.updatedLeaf(mask, l)
+			break
+		}
+
+		// collision, push both leaves down to a new sub-node
+		colHash := hashAt(c.hasher, colLeaf.key, depth)
+		keyHash = c.nextHash(l.key, keyHash, depth)
+		colHash = c.nextHash(colLeaf.key, colHash, depth)
+		ncn = cn.leafToNode(mask, c.dual(l, keyHash, colLeaf, colHash, depth+1, i.gen))
+
+	default:
+		ncn = cn.insertedLeaf(mask, l)
+	}
+
+	if c.gcas(i, main, &mainNode[K, V]{cNode: ncn}) {
+		return old, loaded, false
+	}
+
+	var zero V
+	return zero, false, true
+}
+
+// dual creates the indirection node holding the two leaves l1 and l2 at the given depth, given their hashes for that
+// depth.
+func (c *Ctrie[K, V]) dual(l1 *leaf[K, V], hash1 uint64, l2 *leaf[K, V], hash2 uint64, depth int, gen *generation) *iNode[K, V] {
+	mask1, mask2 := slotMask(hash1, depth), slotMask(hash2, depth)
+	cn := &cNode[K, V]{}
+
+	switch {
+	case mask1 == mask2: // collision again
+		hash1, hash2 = c.nextHash(l1.key, hash1, depth), c.nextHash(l2.key, hash2, depth)
+		cn.appendNode(mask1, c.dual(l1, hash1, l2, hash2, depth+1, gen))
+	case mask1 < mask2:
+		cn.appendLeaf(mask1, l1)
+		cn.appendLeaf(mask2, l2)
+	default:
+		cn.appendLeaf(mask2, l2)
+		cn.appendLeaf(mask1, l1)
+	}
+
+	return newINode(&mainNode[K, V]{cNode: cn}, gen)
+}
+
+// Delete removes the key k and reports whether it was found.
+func (c *Ctrie[K, V]) Delete(k K) bool {
+	_, ok := c.LoadAndDelete(k)
+	return ok
+}
+
+// LoadAndDelete removes the key k and returns its previous value, if any.
+func (c *Ctrie[K, V]) LoadAndDelete(k K) (V, bool) {
+	c.checkWritable()
+	keyHash := c.hasher.Hash(k)
+	for {
+		root := c.readRoot()
+		if v, ok, retry := c.iremove(root, k, keyHash, 0, nil, root.gen); !retry {
+			if ok {
+				c.size.Add(-1)
+			}
+			return v, ok
+		}
+	}
+}
+
+func (c *Ctrie[K, V]) iremove(i *iNode[K, V], k K, keyHash uint64, depth int, parent *iNode[K, V], startGen *generation) (v V, ok, retry bool) {
+	main := c.gcasRead(i)
+	if main.tomb != nil {
+		c.clean(parent, depth-1)
+		return v, false, true
+	}
+
+	cn := main.cNode
+	mask := slotMask(keyHash, depth)
+
+	switch {
+	case cn.nodeMap&mask != 0:
+		child := cn.nodes[cn.nodeIndex(mask)]
+		if child.gen != startGen {
+			if c.gcas(i, main, &mainNode[K, V]{cNode: c.renewed(cn, startGen)}) {
+				return c.iremove(i, k, keyHash, depth, parent, startGen)
+			}
+			return v, false, true
+		}
+
+		if v, ok, retry = c.iremove(child, k, c.nextHash(k, keyHash, depth), depth+1, i, startGen); !ok {
+			return v, ok, retry
+		}
+
+	case cn.dataMap&mask != 0:
+		l := cn.leaves[cn.leafIndex(mask)]
+		if l.key != k {
+			return v, false, false
+		}
+
+		if !c.gcas(i, main, c.toContracted(cn.removedLeaf(mask), depth)) {
+			return v, false, true
+		}
+		v, ok = l.value, true
+
+	default:
+		return v, false, false
+	}
+
+	if parent != nil && c.gcasRead(i).tomb != nil {
+		c.cleanParent(parent, i, k, depth-1, startGen)
+	}
+	return v, true, false
+}
+
+// clean compresses the node i found at the given depth, whose sub-node was entombed.
+func (c *Ctrie[K, V]) clean(i *iNode[K, V], depth int) {
+	if main := c.gcasRead(i); main.cNode != nil {
+		c.gcas(i, main, c.toCompressed(main.cNode, depth))
+	}
+}
+
+// cleanParent inlines the tomb of i, which holds the key k, in its parent found at the given depth.
+func (c *Ctrie[K, V]) cleanParent(parent, i *iNode[K, V], k K, depth int, startGen *generation) {
+	mask := slotMask(hashAt(c.hasher, k, depth), depth)
+
+	for {
+		main := c.gcasRead(parent)
+		if main.tomb != nil {
+			return
+		}
+
+		cn := main.cNode
+		if cn.nodeMap&mask == 0 || cn.nodes[cn.nodeIndex(mask)] != i {
+			return // somebody already cleaned the parent
+		}
+
+		tomb := c.gcasRead(i).tomb
+		if tomb == nil {
+			return
+		}
+
+		ncn := c.toContracted(cn.nodeToLeaf(mask, tomb), depth)
+		if c.gcas(parent, main, ncn) || c.readRoot().gen != startGen {
+			return
+		}
+	}
+}
+
+// ReadOnlySnapshot returns a read-only view of the current content of the trie in O(1), without blocking the writers.
+// The trie is copied lazily afterwards: a writer copies the nodes it changes, once per snapshot. Store, LoadOrStore,
+// Delete and LoadAndDelete panic on the returned Ctrie.
+func (c *Ctrie[K, V]) ReadOnlySnapshot() *Ctrie[K, V] {
+	if c.readOnly {
+		return c
+	}
+
+	for {
+		root := c.readRoot()
+		main := c.gcasRead(root)
+		if c.rdcssRoot(root, main, c.copyToGen(root, &generation{})) {
+			s := &Ctrie[K, V]{readOnly: true, hasher: c.hasher}
+			s.root.Store(root)
+			return s
+		}
+	}
+}
+
+func (c *Ctrie[K, V]) checkWritable() {
+	if c.readOnly {
+		panic("hamt: write to a read-only Ctrie snapshot")
+	}
+}
+
+// Snapshot returns a PersistentHAMT holding the current content of the trie in O(1), without blocking the writers.
+// The map is a view of a ReadOnlySnapshot of the trie, whose nodes never change: Get, GetHashed and Range read the
+// frozen trie, and Clone shares it. The first call of any other method converts the frozen trie to the nodes of the
+// map in O(n), without hashing any key, as both tries have the same shape. As that first call changes the map, it must
+// not run concurrently with other calls on the same map; give every goroutine its own Clone instead.
+func (c *Ctrie[K, V]) Snapshot() *PersistentHAMT[K, V] {
+	m := &PersistentHAMT[K, V]{frozen: c.ReadOnlySnapshot()}
+	m.hasher = c.hasher
+	return m
+}
+
+// ToPersistentHAMT is Snapshot followed by the conversion of the frozen trie, in O(n).
+func (c *Ctrie[K, V]) ToPersistentHAMT() *PersistentHAMT[K, V] {
+	m := c.Snapshot()
+	m.thaw()
+	return m
+}
+
+// thaw converts the frozen Ctrie snapshot m is a view of, if any, to the nodes of m.
+func (m *PersistentHAMT[K, V]) thaw() {
+	s := m.frozen
+	if s == nil {
+		return
+	}
+
+	m.frozen, m.len = nil, 0
+	if m.root = s.toMapNode(s.readRoot(), &m.len); m.root == nil {
+		m.root = newMapNodeWithRef[K, V]()
+	}
+}
+
+// toMapNode converts the sub-trie of i to map nodes, adding its number of keys to count. It returns nil if the sub-trie
+// is empty.
+func (c *Ctrie[K, V]) toMapNode(i *iNode[K, V], count *int) *trieNode[K, *record[K, V]] {
+	cn := c.gcasRead(i).cNode
+	n := newMapNodeWithRef[K, V]()
+
+	for used := cn.dataMap | cn.nodeMap; used != 0; used &= used - 1 {
+		var mask uint64 = 1 << bits.TrailingZeros64(used)
+
+		if cn.dataMap&mask != 0 {
+			l := cn.leaves[cn.leafIndex(mask)]
+			n.appendEntry(mask, newRecord[K, V](l.key, l.value, nil))
+			*count++
+			continue
+		}
+
+		child := cn.nodes[cn.nodeIndex(mask)]
+		if tomb := c.gcasRead(child).tomb; tomb != nil {
+			n.appendEntry(mask, newRecord[K, V](tomb.key, tomb.value, nil))
+			*count++
+			continue
+		}
+		n.appendNode(mask, c.toMapNode(child, count))
+	}
+
+	if n.isEmpty() {
+		n.decRef()
+		return nil
+	}
+	return n
+}
+
+// Range calls f for every key-value pair of a snapshot of the trie, in no particular order, until f returns true.
+// Changes made while Range runs are not seen. Like ReadOnlySnapshot, it makes the following writes copy the nodes they
+// change once.
+func (c *Ctrie[K, V]) Range(f func(k K, v V) bool) {
+	s := c.ReadOnlySnapshot()
+	s.rangeNode(s.readRoot(), f)
+}
+
+func (c *Ctrie[K, V]) rangeNode(i *iNode[K, V], f func(k K, v V) bool) bool {
+	main := c.gcasRead(i)
+	if main.tomb != nil {
+		return f(main.tomb.key, main.tomb.value)
+	}
+
+	for _, l := range main.cNode.leaves {
+		if f(l.key, l.value) {
+			return true
+		}
+	}
+
+	for _, child := range main.cNode.nodes {
+		if c.rangeNode(child, f) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Len returns the number of keys in the trie in O(1). While writes are in flight, it may not count all of them yet.
+// On a read-only snapshot, the keys are counted in O(n).
+func (c *Ctrie[K, V]) Len() int {
+	if !c.readOnly {
+		return int(c.size.Load())
+	}
+
+	count := 0
+	c.rangeNode(c.readRoot(), func(k K, v V) bool {
+		count++
+		return false
+	})
+	return count
+}
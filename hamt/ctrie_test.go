@@ -0,0 +1,387 @@
+package hamt
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCtrie(t *testing.T) {
+	t.Run("Random", func(t *testing.T) {
+		c := NewCtrie[int, int](newIntHasher())
+		expected := make(map[int]int)
+
+		for i := 0; i < 20000; i++ {
+			k := rand.Intn(2000)
+			switch rand.Intn(4) {
+			case 0, 1:
+				c.Store(k, i)
+				expected[k] = i
+			case 2:
+				_, want := expected[k]
+				if got := c.Delete(k); got != want {
+					t.Fatalf("Delete(%d) = %v, want %v", k, got, want)
+				}
+				delete(expected, k)
+			default:
+				want, loaded := expected[k]
+				if !loaded {
+					want = i
+					expected[k] = i
+				}
+				if got, ok := c.LoadOrStore(k, i); got != want || ok != loaded {
+					t.Fatalf("LoadOrStore(%d) = %d, %v, want %d, %v", k, got, ok, want, loaded)
+				}
+			}
+
+			if i%1000 == 999 {
+				m := c.ToPersistentHAMT()
+				validateNode(t, m.root)
+				assertSameMap(t, m.ToMap(), expected)
+				if m.Len() != len(expected) || c.Len() != len(expected) {
+					t.Fatalf("Len() = %d, want %d", m.Len(), len(expected))
+				}
+				if !sameShape(t, m.root, buildMap(expected).root) {
+					t.Fatalf("the snapshot should have the shape of the equivalent PersistentHAMT")
+				}
+			}
+		}
+
+		for k, want := range expected {
+			if got, ok := c.Load(k); !ok || got != want {
+				t.Fatalf("Load(%d) = %d, %v, want %d", k, got, ok, want)
+			}
+		}
+		for k := range expected {
+			c.Delete(k)
+		}
+		if m := c.ToPersistentHAMT(); m.Len() != 0 || !m.root.isEmpty() || c.Len() != 0 {
+			t.Errorf("the trie should be empty after deleting every key")
+		}
+	})
+
+	t.Run("Collision", func(t *testing.T) {
+		keys := []string{"a", "b", "c", "rehash2time_1", "rehash2time_2", "fullhash_1", "fullhash_2", "fullhash_1_collision"}
+
+		c := NewCtrie[string, int](newCollisionHasher[string]())
+		expected := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		for i, k := range keys {
+			c.Store(k, i)
+			expected.Set(k, i)
+		}
+
+		m := c.ToPersistentHAMT()
+		if !Equal(m, expected, intEq) || !sameShape(t, m.root, expected.root) {
+			t.Errorf("ToPersistentHAMT() = %v, want %v", m.ToMap(), expected.ToMap())
+		}
+
+		for i, k := range keys {
+			if i%2 == 0 {
+				c.Delete(k)
+				expected.Delete(k)
+			}
+		}
+
+		m = c.ToPersistentHAMT()
+		if !Equal(m, expected, intEq) || !sameShape(t, m.root, expected.root) {
+			t.Errorf("ToPersistentHAMT() = %v, want %v", m.ToMap(), expected.ToMap())
+		}
+
+		if !panics(func() { c.Store("panic1", 1); c.Store("panic2", 2) }) {
+			t.Errorf("Store() should panic when the hash function is exhausted")
+		}
+	})
+
+	t.Run("SnapshotIsolation", func(t *testing.T) {
+		c := NewCtrie[int, int](newIntHasher())
+		for i := 0; i < 1000; i++ {
+			c.Store(i, i)
+		}
+
+		s := c.ReadOnlySnapshot()
+		m := c.ToPersistentHAMT()
+		for i := 0; i < 1000; i++ {
+			if i%2 == 0 {
+				c.Delete(i)
+			} else {
+				c.Store(i, -i)
+			}
+		}
+		c.Store(1000, 1000)
+
+		for i := 0; i < 1000; i++ {
+			if v, ok := s.Load(i); !ok || v != i {
+				t.Fatalf("the read-only snapshot changed: Load(%d) = %d, %v", i, v, ok)
+			}
+			if v, ok := m.Get(i); !ok || v != i {
+				t.Fatalf("the snapshot changed: Get(%d) = %d, %v", i, v, ok)
+			}
+		}
+		if s.Len() != 1000 || m.Len() != 1000 || c.Len() != 501 {
+			t.Errorf("Len() = %d, %d, %d, want 1000, 1000, 501", s.Len(), m.Len(), c.Len())
+		}
+
+		m.Set(-1, -1)
+		if _, ok := c.Load(-1); ok {
+			t.Errorf("changing the snapshot should not change the trie")
+		}
+
+		if s.ReadOnlySnapshot() != s {
+			t.Errorf("ReadOnlySnapshot() of a read-only snapshot should return it")
+		}
+		if !panics(func() { s.Store(-1, -1) }) || !panics(func() { s.Delete(1) }) {
+			t.Errorf("writing to a read-only snapshot should panic")
+		}
+	})
+
+	t.Run("LazySnapshot", func(t *testing.T) {
+		SetDebug(true)
+		t.Cleanup(func() { SetDebug(false) })
+
+		c := NewCtrie[int, int](newIntHasher())
+		for i := 0; i < 1000; i++ {
+			c.Store(i, i)
+		}
+
+		nodes := LiveNodes()
+		m := c.Snapshot()
+		clone := m.Clone()
+		for i := 0; i < 1000; i++ {
+			c.Store(i, -i)
+		}
+
+		if m.frozen == nil || LiveNodes() != nodes {
+			t.Fatalf("Snapshot() should not convert the trie, %d nodes created", LiveNodes()-nodes)
+		}
+		for i := 0; i < 1000; i++ {
+			if v, ok := m.Get(i); !ok || v != i {
+				t.Fatalf("Get(%d) = %d, %v, want %d", i, v, ok, i)
+			}
+		}
+		count := 0
+		m.Range(func(k, v int) bool {
+			count++
+			return k != v
+		})
+		if count != 1000 || m.frozen == nil {
+			t.Errorf("Range() visited %d keys, want 1000 without converting the trie", count)
+		}
+
+		m.Set(-1, -1)
+		if m.frozen != nil || m.Len() != 1001 {
+			t.Fatalf("Set() should convert the trie, Len() = %d", m.Len())
+		}
+		validateNode(t, m.root)
+		if clone.frozen == nil || clone.Len() != 1000 {
+			t.Errorf("converting a snapshot should not convert its clone, Len() = %d", clone.Len())
+		}
+		if _, ok := c.Load(-1); ok {
+			t.Errorf("changing the snapshot should not change the trie")
+		}
+
+		m.Destroy()
+		clone.Destroy()
+		if LiveNodes() != nodes {
+			t.Errorf("%d nodes leaked by the snapshots", LiveNodes()-nodes)
+		}
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		c := NewCtrie[int, int](newIntHasher())
+		for i := 0; i < 100; i++ {
+			c.Store(i, i*2)
+		}
+
+		seen := make(map[int]int)
+		c.Range(func(k, v int) bool {
+			seen[k] = v
+			c.Delete(k) // not seen by Range
+			return false
+		})
+		if len(seen) != 100 || c.Len() != 0 {
+			t.Errorf("Range() visited %d keys, want 100", len(seen))
+		}
+
+		c.Store(1, 1)
+		c.Store(2, 2)
+		calls := 0
+		c.Range(func(k, v int) bool {
+			calls++
+			return true
+		})
+		if calls != 1 {
+			t.Errorf("Range() should stop when f returns true")
+		}
+	})
+}
+
+func TestCtrieConcurrent(t *testing.T) {
+	const (
+		writers = 8
+		keys    = 2000
+	)
+
+	t.Run("Snapshot", func(t *testing.T) {
+		c := NewCtrie[int, int](newIntHasher())
+
+		// every writer stores its keys in increasing order, then deletes them in the same order, so in a consistent
+		// snapshot the keys of a writer always form a contiguous range.
+		var wg sync.WaitGroup
+		var done int32
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < keys; i++ {
+					c.Store(w*keys+i, w)
+				}
+				for i := 0; i < keys/2; i++ {
+					c.Delete(w*keys + i)
+				}
+			}(w)
+		}
+
+		var readers sync.WaitGroup
+		for r := 0; r < 2; r++ {
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				for atomic.LoadInt32(&done) == 0 {
+					m := c.Snapshot()
+
+					for w := 0; w < writers; w++ {
+						first, last, count := -1, -1, 0
+						for i := 0; i < keys; i++ {
+							if _, ok := m.Get(w*keys + i); ok {
+								if first < 0 {
+									first = i
+								}
+								last = i
+								count++
+							}
+						}
+						if count > 0 && last-first+1 != count {
+							t.Errorf("inconsistent snapshot: writer %d has %d keys in [%d, %d]", w, count, first, last)
+							return
+						}
+					}
+				}
+			}()
+		}
+
+		wg.Wait()
+		atomic.StoreInt32(&done, 1)
+		readers.Wait()
+
+		m := c.ToPersistentHAMT()
+		validateNode(t, m.root)
+		if m.Len() != writers*keys/2 {
+			t.Errorf("Len() = %d, want %d", m.Len(), writers*keys/2)
+		}
+		for w := 0; w < writers; w++ {
+			for i := 0; i < keys; i++ {
+				if v, ok := c.Load(w*keys + i); ok != (i >= keys/2) || (ok && v != w) {
+					t.Fatalf("Load(%d) = %d, %v", w*keys+i, v, ok)
+				}
+			}
+		}
+	})
+
+	t.Run("LoadOrStore", func(t *testing.T) {
+		c := NewCtrie[int, int](newIntHasher())
+
+		var stored [writers]int
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < keys; i++ {
+					if actual, loaded := c.LoadOrStore(i, w); !loaded {
+						stored[w]++
+					} else if actual == w {
+						t.Errorf("LoadOrStore(%d) loaded the value of its own goroutine", i)
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		total := 0
+		for _, n := range stored {
+			total += n
+		}
+		if total != keys || c.Len() != keys {
+			t.Errorf("%d keys were stored, want %d", total, keys)
+		}
+	})
+
+	t.Run("Mixed", func(t *testing.T) {
+		c := NewCtrie[string, int](newCollisionHasher[string]())
+		names := []string{"a", "b", "c", "d", "e", "rehash2time_1", "rehash2time_2", "fullhash_1", "fullhash_2", "fullhash_1_collision"}
+
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(seed int64) {
+				defer wg.Done()
+				r := rand.New(rand.NewSource(seed))
+				for i := 0; i < 5000; i++ {
+					k := names[r.Intn(len(names))]
+					switch r.Intn(4) {
+					case 0:
+						c.Store(k, i)
+					case 1:
+						c.Delete(k)
+					case 2:
+						c.LoadOrStore(k, i)
+					default:
+						c.Load(k)
+					}
+				}
+			}(int64(w))
+		}
+		wg.Wait()
+
+		m := c.ToPersistentHAMT()
+		expected := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		c.Range(func(k string, v int) bool {
+			expected.Set(k, v)
+			return false
+		})
+		if !Equal(m, expected, intEq) || !sameShape(t, m.root, expected.root) {
+			t.Errorf("ToPersistentHAMT() = %v, want %v", m.ToMap(), expected.ToMap())
+		}
+		if c.Len() != expected.Len() {
+			t.Errorf("Len() = %d, want %d", c.Len(), expected.Len())
+		}
+	})
+}
+
+func BenchmarkCtrie(b *testing.B) {
+	c := NewCtrie[int, int](newIntHasher())
+	for i := 0; i < 100000; i++ {
+		c.Store(i, i)
+	}
+
+	b.Run("Load", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				c.Load(i % 100000)
+				i++
+			}
+		})
+	})
+
+	b.Run("Store", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				c.Store(i%100000, i)
+				i++
+			}
+		})
+	})
+}
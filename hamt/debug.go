@@ -0,0 +1,52 @@
+package hamt
+
+import "sync/atomic"
+
+// The debug mode counts the records and nodes of the maps that are alive, that is still referenced by a version of a
+// map, and panics when one of them is released more times than it was referenced. It is meant for tests: the
+// counters cost an atomic operation per allocation and release, and they are global to the process, shared by all the
+// maps of the program. Tests reading them must not run in parallel with other tests using the package, so they can't
+// call t.Parallel. The hamttest package builds a leak and double-release check on them.
+var (
+	debugMode   int32 // atomic, 1 if the debug mode is on
+	liveRecords int64 // atomic
+	liveNodes   int64 // atomic
+)
+
+// SetDebug turns the debug mode on or off. Only the records and nodes created while the debug mode is on are counted,
+// until they are released, whether the debug mode is still on or not.
+func SetDebug(on bool) {
+	if on {
+		atomic.StoreInt32(&debugMode, 1)
+	} else {
+		atomic.StoreInt32(&debugMode, 0)
+	}
+}
+
+// LiveRecords returns the number of records created in debug mode that are not released yet. Once every version of
+// the maps has been destroyed, it should be back to its value before the maps were created, and every release
+// callback should have run exactly once.
+func LiveRecords() int64 {
+	return atomic.LoadInt64(&liveRecords)
+}
+
+// LiveNodes returns the number of trie nodes created in debug mode that are not released yet.
+func LiveNodes() int64 {
+	return atomic.LoadInt64(&liveNodes)
+}
+
+// debugOn reports whether the debug mode is on, in which case the new records and nodes are counted.
+func debugOn() bool {
+	return atomic.LoadInt32(&debugMode) != 0
+}
+
+// debugRelease is called when the refCount of a counted record or node drops to refCount. It panics on a double
+// release and decreases the counter when the object is freed.
+func debugRelease(refCount int32, live *int64, kind string) {
+	switch {
+	case refCount < 0:
+		panic("hamt: " + kind + " released more times than it was referenced")
+	case refCount == 0:
+		atomic.AddInt64(live, -1)
+	}
+}
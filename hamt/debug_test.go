@@ -0,0 +1,29 @@
+package hamt
+
+import "testing"
+
+func TestDebugMode(t *testing.T) {
+	t.Run("DoubleRelease", func(t *testing.T) {
+		SetDebug(true)
+		t.Cleanup(func() { SetDebug(false) })
+
+		r := newRecord[int, int](1, 1, nil)
+		r.decRef()
+		if !panics(r.decRef) {
+			t.Errorf("releasing a record twice should panic in debug mode")
+		}
+
+		n := newMapNodeWithRef[int, int]()
+		n.decRef()
+		if !panics(n.decRef) {
+			t.Errorf("releasing a node twice should panic in debug mode")
+		}
+
+		SetDebug(false)
+		r = newRecord[int, int](1, 1, nil)
+		r.decRef()
+		if panics(r.decRef) {
+			t.Errorf("records created with the debug mode off should not be checked")
+		}
+	})
+}
@@ -0,0 +1,352 @@
+package hamt
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// binaryFormatVersion is the first byte of the output of MarshalBinary.
+const binaryFormatVersion = 1
+
+var errMalformedBinary = errors.New("hamt: malformed binary data")
+
+// The Hasher of a map can't be serialized. The decoding methods below keep the Hasher of the receiver, so a map
+// created with NewPersistentHAMT(h) is decoded with h, and fall back on NewDefaultHasher for a zero PersistentHAMT.
+// Decoding replaces the content of the receiver; other versions sharing nodes with it are not affected.
+
+// reset replaces the content of the map with the entries of src.
+func (m *PersistentHAMT[K, V]) reset(src map[K]V) {
+	if m.hasher == nil {
+		m.hasher = NewDefaultHasher[K]()
+	}
+
+	built := FromMap(m.hasher, src)
+	m.root.decRef()
+	m.root, m.len, m.frozen = built.root, built.len, nil
+}
+
+func isStringKind[K comparable]() bool {
+	return reflect.TypeOf((*K)(nil)).Elem().Kind() == reflect.String
+}
+
+// MarshalJSON encodes the map as a JSON object if the keys are strings, or as an array of [key, value] pairs
+// otherwise.
+func (m *PersistentHAMT[K, V]) MarshalJSON() ([]byte, error) {
+	if isStringKind[K]() {
+		return json.Marshal(m.ToMap())
+	}
+
+	pairs := make([][2]any, 0, m.Len())
+	m.Range(func(k K, v V) bool {
+		pairs = append(pairs, [2]any{k, v})
+		return false
+	})
+	return json.Marshal(pairs)
+}
+
+// UnmarshalJSON decodes the output of MarshalJSON into the map.
+func (m *PersistentHAMT[K, V]) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	var src map[K]V
+
+	if isStringKind[K]() {
+		if err := json.Unmarshal(data, &src); err != nil {
+			return err
+		}
+	} else {
+		var pairs [][2]json.RawMessage
+		if err := json.Unmarshal(data, &pairs); err != nil {
+			return err
+		}
+
+		src = make(map[K]V, len(pairs))
+		for _, pair := range pairs {
+			var k K
+			var v V
+			if err := json.Unmarshal(pair[0], &k); err != nil {
+				return err
+			}
+			if err := json.Unmarshal(pair[1], &v); err != nil {
+				return err
+			}
+			src[k] = v
+		}
+	}
+
+	m.reset(src)
+	return nil
+}
+
+// GobEncode encodes the entries of the map with encoding/gob.
+func (m *PersistentHAMT[K, V]) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(m.Entries()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode decodes the output of GobEncode into the map.
+func (m *PersistentHAMT[K, V]) GobDecode(data []byte) error {
+	var entries []Entry[K, V]
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entries); err != nil {
+		return err
+	}
+
+	src := make(map[K]V, len(entries))
+	for _, e := range entries {
+		src[e.Key] = e.Value
+	}
+
+	m.reset(src)
+	return nil
+}
+
+// MarshalBinary encodes the map in a compact binary format: a version byte, the number of entries as a uvarint, then
+// every key followed by its value. Booleans, integers, floats, complex numbers and strings are supported, as well as
+// arrays, slices and structs made of them and types implementing encoding.BinaryMarshaler. Integers are encoded as
+// varints and strings, slices and marshaled values are prefixed with their length.
+func (m *PersistentHAMT[K, V]) MarshalBinary() ([]byte, error) {
+	buf := []byte{binaryFormatVersion}
+	buf = binary.AppendUvarint(buf, uint64(m.Len()))
+
+	var err error
+	m.Range(func(k K, v V) bool {
+		if buf, err = appendBinary(buf, reflect.ValueOf(&k).Elem()); err != nil {
+			return true
+		}
+		buf, err = appendBinary(buf, reflect.ValueOf(&v).Elem())
+		return err != nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes the output of MarshalBinary into the map.
+func (m *PersistentHAMT[K, V]) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] != binaryFormatVersion {
+		return errors.New("hamt: unsupported binary format version")
+	}
+
+	count, n := binary.Uvarint(data[1:])
+	if n <= 0 {
+		return errMalformedBinary
+	}
+	data = data[1+n:]
+
+	// every entry takes at least two bytes, don't trust a larger count
+	capacity := count
+	if limit := uint64(len(data) / 2); capacity > limit {
+		capacity = limit
+	}
+	src := make(map[K]V, int(capacity))
+
+	var err error
+	for i := uint64(0); i < count; i++ {
+		var k K
+		var v V
+		if data, err = readBinary(data, reflect.ValueOf(&k).Elem()); err != nil {
+			return err
+		}
+		if data, err = readBinary(data, reflect.ValueOf(&v).Elem()); err != nil {
+			return err
+		}
+		src[k] = v
+	}
+
+	if len(data) != 0 {
+		return errMalformedBinary
+	}
+
+	m.reset(src)
+	return nil
+}
+
+var binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+var binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+
+func appendBinary(buf []byte, v reflect.Value) ([]byte, error) {
+	if v.Type().Implements(binaryMarshalerType) && v.Addr().Type().Implements(binaryUnmarshalerType) {
+		data, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(data)))
+		return append(buf, data...), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.AppendUvarint(buf, v.Uint()), nil
+	case reflect.Float32:
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.Float())), nil
+	case reflect.Complex64:
+		c := v.Complex()
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(real(c))))
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(imag(c)))), nil
+	case reflect.Complex128:
+		c := v.Complex()
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(real(c)))
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(imag(c))), nil
+	case reflect.String:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		return append(buf, v.String()...), nil
+	case reflect.Slice:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return append(buf, v.Bytes()...), nil
+		}
+		fallthrough
+	case reflect.Array:
+		var err error
+		for i := 0; i < v.Len() && err == nil; i++ {
+			buf, err = appendBinary(buf, v.Index(i))
+		}
+		return buf, err
+	case reflect.Struct:
+		var err error
+		for i := 0; i < v.NumField() && err == nil; i++ {
+			if !v.Type().Field(i).IsExported() {
+				return nil, fmt.Errorf("hamt: binary encoding of unexported field %s of %s is not supported", v.Type().Field(i).Name, v.Type())
+			}
+			buf, err = appendBinary(buf, v.Field(i))
+		}
+		return buf, err
+	}
+
+	return nil, fmt.Errorf("hamt: binary encoding of %s is not supported", v.Type())
+}
+
+// readLength reads a uvarint length and checks that at least length bytes of unit size follow.
+func readLength(data []byte, unit int) (int, []byte, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length > uint64(len(data)-n)/uint64(unit) {
+		return 0, nil, errMalformedBinary
+	}
+	return int(length), data[n:], nil
+}
+
+func readBinary(data []byte, v reflect.Value) ([]byte, error) {
+	if v.Type().Implements(binaryMarshalerType) && v.Addr().Type().Implements(binaryUnmarshalerType) {
+		length, data, err := readLength(data, 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(data[:length]); err != nil {
+			return nil, err
+		}
+		return data[length:], nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if len(data) < 1 {
+			return nil, errMalformedBinary
+		}
+		v.SetBool(data[0] != 0)
+		return data[1:], nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, n := binary.Varint(data)
+		if n <= 0 || v.OverflowInt(i) {
+			return nil, errMalformedBinary
+		}
+		v.SetInt(i)
+		return data[n:], nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, n := binary.Uvarint(data)
+		if n <= 0 || v.OverflowUint(u) {
+			return nil, errMalformedBinary
+		}
+		v.SetUint(u)
+		return data[n:], nil
+	case reflect.Float32:
+		if len(data) < 4 {
+			return nil, errMalformedBinary
+		}
+		v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(data))))
+		return data[4:], nil
+	case reflect.Float64:
+		if len(data) < 8 {
+			return nil, errMalformedBinary
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(data)))
+		return data[8:], nil
+	case reflect.Complex64:
+		if len(data) < 8 {
+			return nil, errMalformedBinary
+		}
+		re := math.Float32frombits(binary.LittleEndian.Uint32(data))
+		im := math.Float32frombits(binary.LittleEndian.Uint32(data[4:]))
+		v.SetComplex(complex(float64(re), float64(im)))
+		return data[8:], nil
+	case reflect.Complex128:
+		if len(data) < 16 {
+			return nil, errMalformedBinary
+		}
+		re := math.Float64frombits(binary.LittleEndian.Uint64(data))
+		im := math.Float64frombits(binary.LittleEndian.Uint64(data[8:]))
+		v.SetComplex(complex(re, im))
+		return data[16:], nil
+	case reflect.String:
+		length, data, err := readLength(data, 1)
+		if err != nil {
+			return nil, err
+		}
+		v.SetString(string(data[:length]))
+		return data[length:], nil
+	case reflect.Slice:
+		length, data, err := readLength(data, 1)
+		if err != nil {
+			return nil, err
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte(nil), data[:length]...))
+			return data[length:], nil
+		}
+
+		v.Set(reflect.MakeSlice(v.Type(), length, length))
+		for i := 0; i < length && err == nil; i++ {
+			data, err = readBinary(data, v.Index(i))
+		}
+		return data, err
+	case reflect.Array:
+		var err error
+		for i := 0; i < v.Len() && err == nil; i++ {
+			data, err = readBinary(data, v.Index(i))
+		}
+		return data, err
+	case reflect.Struct:
+		var err error
+		for i := 0; i < v.NumField() && err == nil; i++ {
+			if !v.Type().Field(i).IsExported() {
+				return nil, fmt.Errorf("hamt: binary encoding of unexported field %s of %s is not supported", v.Type().Field(i).Name, v.Type())
+			}
+			data, err = readBinary(data, v.Field(i))
+		}
+		return data, err
+	}
+
+	return nil, fmt.Errorf("hamt: binary encoding of %s is not supported", v.Type())
+}
@@ -0,0 +1,345 @@
+package hamt
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+type point struct {
+	X, Y  int32
+	Label string
+}
+
+func TestEncodingJSON(t *testing.T) {
+	t.Run("StringKeys", func(t *testing.T) {
+		src := map[string]int{"a": 1, "b": 2, "fullhash_1": 3, "fullhash_2": 4}
+		m := FromMap[string, int](newCollisionHasher[string](), src)
+
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("json.Marshal() error = %v", err)
+		}
+		if !strings.HasPrefix(string(data), "{") {
+			t.Errorf("a map with string keys should be encoded as a JSON object, got %s", data)
+		}
+
+		decoded := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		if err := json.Unmarshal(data, decoded); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		if _, ok := decoded.hasher.(*collisionHasher[string]); !ok {
+			t.Errorf("decoding should keep the hasher of the receiver")
+		}
+		validateNode(t, decoded.root)
+		assertSameMap(t, decoded.ToMap(), src)
+	})
+
+	t.Run("IntKeys", func(t *testing.T) {
+		src := make(map[int]int)
+		for i := 0; i < 1000; i++ {
+			src[i*3] = i
+		}
+		m := FromMap[int, int](newIntHasher(), src)
+
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("json.Marshal() error = %v", err)
+		}
+		if !strings.HasPrefix(string(data), "[[") {
+			t.Errorf("a map with int keys should be encoded as an array of pairs, got %.20s", data)
+		}
+
+		var decoded PersistentHAMT[int, int]
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		validateNode(t, decoded.root)
+		assertSameMap(t, decoded.ToMap(), src)
+
+		decoded.Set(-1, -1)
+		if v, ok := decoded.Get(-1); !ok || v != -1 || decoded.Len() != len(src)+1 {
+			t.Errorf("a decoded zero map should be usable with the default hasher")
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		m := NewPersistentHAMT[string, int](newHasher[string]())
+		m.Set("old", 1)
+		clone := m.Clone()
+
+		if err := json.Unmarshal([]byte(`{"new": 2}`), m); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		assertSameMap(t, m.ToMap(), map[string]int{"new": 2})
+		assertSameMap(t, clone.ToMap(), map[string]int{"old": 1})
+
+		if err := json.Unmarshal([]byte(`null`), m); err != nil || m.Len() != 1 {
+			t.Errorf("decoding null should leave the map unchanged")
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		var m PersistentHAMT[int, string]
+		for _, data := range []string{`{"a": "b"}`, `[[1]]`, `[["a", "b"]]`, `[[1, 2]]`, `[`} {
+			if err := json.Unmarshal([]byte(data), &m); err == nil {
+				t.Errorf("json.Unmarshal(%s) should fail", data)
+			}
+		}
+	})
+}
+
+func TestEncodingGob(t *testing.T) {
+	src := make(map[point]string)
+	for i := int32(0); i < 500; i++ {
+		src[point{X: i, Y: -i, Label: generateUUID()}] = generateUUID()
+	}
+	m := FromMap[point, string](NewDefaultHasher[point](), src)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	var decoded PersistentHAMT[point, string]
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	validateNode(t, decoded.root)
+	assertSameMap(t, decoded.ToMap(), src)
+
+	if err := decoded.GobDecode([]byte("garbage")); err == nil {
+		t.Errorf("GobDecode() should fail on malformed input")
+	}
+}
+
+func TestEncodingBinary(t *testing.T) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		src := make(map[int]int)
+		for i := 0; i < 1000; i++ {
+			src[i*7-3000] = i
+		}
+		m := FromMap[int, int](newIntHasher(), src)
+
+		data, err := m.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary() error = %v", err)
+		}
+		jsonData, _ := json.Marshal(m)
+		if len(data) >= len(jsonData) {
+			t.Errorf("binary encoding (%d bytes) should be smaller than JSON (%d bytes)", len(data), len(jsonData))
+		}
+
+		decoded := NewPersistentHAMT[int, int](newIntHasher())
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary() error = %v", err)
+		}
+		validateNode(t, decoded.root)
+		assertSameMap(t, decoded.ToMap(), src)
+	})
+
+	t.Run("Composite", func(t *testing.T) {
+		type value struct {
+			Names  []string
+			Raw    []byte
+			Ratio  float64
+			Flags  [2]bool
+			Stamp  time.Time
+			Weight complex64
+		}
+
+		src := map[point]value{
+			{1, 2, "a"}:  {Names: []string{"x", "y"}, Raw: []byte{1, 2}, Ratio: 0.5, Flags: [2]bool{true, false}, Stamp: time.Unix(1000, 0).UTC(), Weight: 1 + 2i},
+			{-1, 0, ""}:  {},
+			{3, 4, "日本"}: {Names: []string{}, Ratio: -1e300},
+		}
+		m := FromMap[point, value](NewDefaultHasher[point](), src)
+
+		data, err := m.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary() error = %v", err)
+		}
+
+		var decoded PersistentHAMT[point, value]
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary() error = %v", err)
+		}
+		if decoded.Len() != len(src) {
+			t.Fatalf("decoded.Len() = %d, want %d", decoded.Len(), len(src))
+		}
+		for k, want := range src {
+			got, ok := decoded.Get(k)
+			if !ok || len(got.Names) != len(want.Names) || string(got.Raw) != string(want.Raw) || got.Ratio != want.Ratio ||
+				got.Flags != want.Flags || !got.Stamp.Equal(want.Stamp) || got.Weight != want.Weight {
+				t.Errorf("decoded.Get(%v) = %v, want %v", k, got, want)
+			}
+		}
+	})
+
+	t.Run("Complex", func(t *testing.T) {
+		m64 := NewPersistentHAMT[int, complex64](newIntHasher())
+		m64.Set(1, 1.5-2i)
+		m128 := NewPersistentHAMT[int, complex128](newIntHasher())
+		m128.Set(1, 1.5-2i)
+
+		// version, count and key take a byte each, then the real and imaginary parts
+		data64, _ := m64.MarshalBinary()
+		data128, _ := m128.MarshalBinary()
+		if len(data64) != 3+8 || len(data128) != 3+16 {
+			t.Fatalf("len(MarshalBinary()) = %d, %d, want %d, %d", len(data64), len(data128), 3+8, 3+16)
+		}
+
+		decoded64 := NewPersistentHAMT[int, complex64](newIntHasher())
+		if err := decoded64.UnmarshalBinary(data64); err != nil {
+			t.Fatalf("UnmarshalBinary() error = %v", err)
+		}
+		decoded128 := NewPersistentHAMT[int, complex128](newIntHasher())
+		if err := decoded128.UnmarshalBinary(data128); err != nil {
+			t.Fatalf("UnmarshalBinary() error = %v", err)
+		}
+		if v, _ := decoded64.Get(1); v != 1.5-2i {
+			t.Errorf("decoded64.Get(1) = %v, want %v", v, 1.5-2i)
+		}
+		if v, _ := decoded128.Get(1); v != 1.5-2i {
+			t.Errorf("decoded128.Get(1) = %v, want %v", v, 1.5-2i)
+		}
+		if err := decoded64.UnmarshalBinary(data64[:len(data64)-1]); err == nil {
+			t.Errorf("UnmarshalBinary() should fail on a truncated complex64")
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		m := NewPersistentHAMT[int, map[int]int](newIntHasher())
+		m.Set(1, map[int]int{})
+		if _, err := m.MarshalBinary(); err == nil {
+			t.Errorf("MarshalBinary() should fail on map values")
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		m := NewPersistentHAMT[string, int](newHasher[string]())
+		m.Set("key", 1)
+		data, _ := m.MarshalBinary()
+
+		inputs := [][]byte{
+			nil,
+			{0},
+			{binaryFormatVersion},
+			data[:len(data)-1],
+			append(append([]byte(nil), data...), 0),
+			{binaryFormatVersion, 1, 0xff, 0xff, 0xff, 0xff, 0x0f},
+			{binaryFormatVersion, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		}
+		for _, data := range inputs {
+			if err := m.UnmarshalBinary(data); err == nil {
+				t.Errorf("UnmarshalBinary(%v) should fail", data)
+			}
+		}
+
+		if v, ok := m.Get("key"); !ok || v != 1 || m.Len() != 1 {
+			t.Errorf("a failed decoding should leave the map unchanged")
+		}
+	})
+}
+
+func TestDefaultHasher(t *testing.T) {
+	type key struct {
+		A int16
+		B float64
+		C [2]string
+	}
+
+	h := NewDefaultHasher[key]()
+	k1 := key{A: 1, B: 0, C: [2]string{"ab", "c"}}
+	k2 := key{A: 1, B: -1 * 0.0, C: [2]string{"ab", "c"}}
+	if k1 != k2 || h.Hash(k1) != h.Hash(k2) || h.Rehash(k1, 1) != h.Rehash(k2, 1) {
+		t.Errorf("equal keys should have the same hash")
+	}
+
+	distinct := []key{
+		{A: 1}, {A: -1}, {B: 1}, {},
+		{C: [2]string{"a", "bc"}}, {C: [2]string{"ab", "c"}},
+	}
+	seen := make(map[uint64]key)
+	for _, k := range distinct {
+		if other, ok := seen[h.Hash(k)]; ok {
+			t.Errorf("Hash(%v) == Hash(%v)", k, other)
+		}
+		seen[h.Hash(k)] = k
+	}
+
+	if h.Rehash(k1, 1) == h.Rehash(k1, 2) {
+		t.Errorf("Rehash should depend on the number of previous hashes")
+	}
+
+	type ref struct {
+		P *int
+		C complex64
+	}
+	one := 1
+	hr := NewDefaultHasher[ref]()
+	if hr.Hash(ref{P: &one, C: 1i}) != hr.Hash(ref{P: &one, C: 1i}) {
+		t.Errorf("equal keys holding a pointer should have the same hash")
+	}
+	if hr.Hash(ref{P: &one}) == hr.Hash(ref{P: new(int)}) || hr.Hash(ref{C: 1}) == hr.Hash(ref{C: 1i}) {
+		t.Errorf("keys holding different pointers or complex numbers should not have the same hash")
+	}
+}
+
+func TestDefaultHasherAllocs(t *testing.T) {
+	type point struct {
+		X, Y int
+		Name string
+	}
+
+	intHasher, stringHasher := NewDefaultHasher[int](), NewDefaultHasher[string]()
+	pointHasher, seededHasher := NewDefaultHasher[point](), NewSeededHasher[point]()
+	for name, hash := range map[string]func(){
+		"int":    func() { intHasher.Hash(1 << 20) },
+		"string": func() { stringHasher.Rehash("key", 1) },
+		"struct": func() { pointHasher.Rehash(point{1, 2, "p"}, 1) },
+		"seeded": func() { seededHasher.Hash(point{1, 2, "p"}) },
+	} {
+		if allocs := testing.AllocsPerRun(100, hash); allocs != 0 {
+			t.Errorf("hashing a %s key allocates %v times, want 0", name, allocs)
+		}
+	}
+}
+
+func TestNaNKeys(t *testing.T) {
+	type key struct {
+		F float64
+		C complex128
+	}
+
+	for name, h := range map[string]Hasher[float64]{"default": NewDefaultHasher[float64](), "seeded": NewSeededHasher[float64]()} {
+		m := NewPersistentHAMT[float64, int](h)
+		for i := 0; i < 1000; i++ {
+			m.Set(math.NaN(), i)
+		}
+		m.Set(1, 1)
+
+		if m.Len() != 1001 {
+			t.Errorf("%s: Len() = %d, want 1001: every NaN is a new key", name, m.Len())
+		}
+		if _, ok := m.Get(math.NaN()); ok {
+			t.Errorf("%s: Get(NaN) found a key, NaN is not equal to any key", name)
+		}
+		if v, ok := m.Get(1); !ok || v != 1 {
+			t.Errorf("%s: Get(1) = %d, %v, want 1, true", name, v, ok)
+		}
+		validateNode(t, m.root)
+	}
+
+	s := NewPersistentSet[key](NewDefaultHasher[key]())
+	for i := 0; i < 1000; i++ {
+		s.Add(key{F: float64(i % 2), C: complex(0, math.NaN())})
+	}
+	if s.Len() != 1000 {
+		t.Errorf("Len() = %d, want 1000: keys holding a NaN are never equal", s.Len())
+	}
+}
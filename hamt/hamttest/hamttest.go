@@ -0,0 +1,69 @@
+// Package hamttest provides helpers for the tests of code storing resources in the maps of the hamt package, such as
+// file handles released by the release callback of PersistentHAMT.Put.
+package hamttest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/nnhatnam/immutable/hamt"
+)
+
+// ReleaseTracker hands out release callbacks and checks, once every version of the maps is destroyed, that each of
+// them ran exactly once and that no record or node created meanwhile is still alive.
+//
+// A ReleaseTracker turns the debug mode of the hamt package on until the end of the test. The debug mode and the
+// counters of live records and nodes are global to the process (see hamt.SetDebug), so a test using a ReleaseTracker
+// must not run in parallel with other tests using the hamt package: don't call t.Parallel in it, nor in the tests of
+// the same package.
+type ReleaseTracker struct {
+	tb    testing.TB
+	mu    sync.Mutex
+	calls []int // calls[i] is the number of times the i-th callback ran
+
+	records, nodes int64 // the live records and nodes when the tracker was created
+}
+
+// NewReleaseTracker turns the debug mode on and returns a tracker for the maps created from now on.
+func NewReleaseTracker(tb testing.TB) *ReleaseTracker {
+	hamt.SetDebug(true)
+	tb.Cleanup(func() { hamt.SetDebug(false) })
+
+	return &ReleaseTracker{tb: tb, records: hamt.LiveRecords(), nodes: hamt.LiveNodes()}
+}
+
+// Release returns a new release callback of tr, to be passed to PersistentHAMT.Put. It can wrap the callback
+// releasing the actual resource.
+func Release[K comparable, V any](tr *ReleaseTracker) func(key K, value V) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	id := len(tr.calls)
+	tr.calls = append(tr.calls, 0)
+	return func(key K, value V) {
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+		tr.calls[id]++
+	}
+}
+
+// AssertReleased fails the test unless every callback ran exactly once and every record and node created since the
+// tracker was created is released. Call it once every version of the maps is destroyed.
+func (tr *ReleaseTracker) AssertReleased() {
+	tr.tb.Helper()
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	for id, n := range tr.calls {
+		if n != 1 {
+			tr.tb.Errorf("release callback %d ran %d times", id, n)
+		}
+	}
+
+	if live := hamt.LiveRecords() - tr.records; live != 0 {
+		tr.tb.Errorf("%d records are still alive", live)
+	}
+	if live := hamt.LiveNodes() - tr.nodes; live != 0 {
+		tr.tb.Errorf("%d nodes are still alive", live)
+	}
+}
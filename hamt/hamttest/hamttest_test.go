@@ -0,0 +1,58 @@
+package hamttest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nnhatnam/immutable/hamt"
+)
+
+// recorder records the errors reported to it instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestReleaseTracker(t *testing.T) {
+	t.Run("Released", func(t *testing.T) {
+		r := &recorder{TB: t}
+		tr := NewReleaseTracker(r)
+
+		m := hamt.NewPersistentHAMT[int, int](hamt.NewDefaultHasher[int]())
+		for i := 0; i < 100; i++ {
+			m.Put(i, i, Release[int, int](tr))
+		}
+		clone := m.Clone()
+		clone.Set(0, -1)
+
+		m.Destroy()
+		clone.Destroy()
+		tr.AssertReleased()
+		if len(r.errors) != 0 {
+			t.Errorf("AssertReleased() reported %v", r.errors)
+		}
+	})
+
+	t.Run("Leaked", func(t *testing.T) {
+		r := &recorder{TB: t}
+		tr := NewReleaseTracker(r)
+
+		m := hamt.NewPersistentHAMT[int, int](hamt.NewDefaultHasher[int]())
+		m.Put(1, 1, Release[int, int](tr))
+		release := Release[int, int](tr)
+		release(2, 2)
+		release(2, 2)
+
+		tr.AssertReleased()
+		if len(r.errors) != 4 {
+			t.Errorf("AssertReleased() reported %v, want a missed and a double release, a live record and a live node", r.errors)
+		}
+		m.Destroy()
+	})
+}
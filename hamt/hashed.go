@@ -0,0 +1,108 @@
+package hamt
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+// rehashLevels is the number of rehashes a key can need, one for every group of exhaustedLevel+1 levels past the
+// first one.
+const rehashLevels = maxDepth / (exhaustedLevel + 1)
+
+// HashedKey is a key along with its hash, so it can be looked up in several maps without being hashed again.
+// The level-0 hash is computed when the HashedKey is created; the rehashes used by the deeper levels of the trie
+// are computed on first use and cached. A HashedKey can be used by several goroutines at once.
+// A HashedKey is only used as such by the maps with the same hasher; other maps hash the key as usual.
+type HashedKey[K comparable] struct {
+	key    K
+	hasher Hasher[K]
+	hash   uint64
+
+	rehashes [rehashLevels]uint64 // rehashes[i] is Rehash(key, i+1), valid if bit i of ready is set
+	ready    uint32
+}
+
+// NewHashedKey hashes the key with h.
+func NewHashedKey[K comparable](h Hasher[K], key K) *HashedKey[K] {
+	return &HashedKey[K]{
+		key:    key,
+		hasher: h,
+		hash:   h.Hash(key),
+	}
+}
+
+// HashKey hashes the key with the hasher of the map, for use with GetHashed, SetHashed and DeleteHashed on the map
+// and the versions derived from it.
+func (m *PersistentHAMT[K, V]) HashKey(k K) *HashedKey[K] {
+	return NewHashedKey(m.hasher, k)
+}
+
+// Key returns the key.
+func (hk *HashedKey[K]) Key() K {
+	return hk.key
+}
+
+// hashAt returns the hash of the key used at the given depth of the trie, like hashAt.
+func (hk *HashedKey[K]) hashAt(depth int) uint64 {
+	prevHashCount := depth / (exhaustedLevel + 1)
+	if prevHashCount == 0 {
+		return hk.hash
+	} else if depth > maxDepth {
+		return hashAt(hk.hasher, hk.key, depth) // panics
+	}
+
+	bit := uint32(1) << (prevHashCount - 1)
+	if atomic.LoadUint32(&hk.ready)&bit != 0 {
+		return atomic.LoadUint64(&hk.rehashes[prevHashCount-1])
+	}
+
+	hash := hk.hasher.Rehash(hk.key, prevHashCount)
+	atomic.StoreUint64(&hk.rehashes[prevHashCount-1], hash)
+	for {
+		ready := atomic.LoadUint32(&hk.ready)
+		if atomic.CompareAndSwapUint32(&hk.ready, ready, ready|bit) {
+			return hash
+		}
+	}
+}
+
+// hashedBy returns hk if it was hashed by the hasher of the map, or nil if the map has to hash the key itself.
+func (m *PersistentHAMT[K, V]) hashedBy(hk *HashedKey[K]) *HashedKey[K] {
+	// hashers of a type that can't be compared are never the same, instead of making == panic
+	if t := reflect.TypeOf(m.hasher); t == nil || !t.Comparable() || reflect.TypeOf(hk.hasher) != t {
+		return nil
+	}
+	if hk.hasher == m.hasher {
+		return hk
+	}
+	return nil
+}
+
+// GetHashed is like Get, without hashing the key.
+func (m *PersistentHAMT[K, V]) GetHashed(hk *HashedKey[K]) (_ V, _ bool) {
+	if m.frozen != nil {
+		return m.frozen.Load(hk.key)
+	}
+	if m.root == nil {
+		return
+	}
+
+	h := m.hashedBy(hk)
+	if r, ok := m.getEntry(m.root, hk.key, m.keyHash(hk.key, h, 0), h, 0); ok {
+		return r.value, true
+	}
+	return
+}
+
+// SetHashed is like Set, without hashing the key.
+func (m *PersistentHAMT[K, V]) SetHashed(hk *HashedKey[K], v V) {
+	m.thaw()
+	m.alterRoot(hk.key, m.hashedBy(hk), func(*record[K, V], bool) (*record[K, V], bool) {
+		return newRecord[K, V](hk.key, v, nil), true
+	})
+}
+
+// DeleteHashed is like Delete, without hashing the key.
+func (m *PersistentHAMT[K, V]) DeleteHashed(hk *HashedKey[K]) bool {
+	return m.deleteKey(hk.key, m.hashedBy(hk))
+}
@@ -0,0 +1,123 @@
+package hamt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// levelHasher gives the same level-0 hash to every key with the same last digit, so the keys are told apart by
+// their rehashes only.
+type levelHasher struct{}
+
+func (levelHasher) Hash(key int) uint64 {
+	return uint64(key % 10)
+}
+
+func (levelHasher) Rehash(key int, level int) uint64 {
+	return murmur3.Sum64WithSeed([]byte(fmt.Sprint(key)), uint32(level))
+}
+
+func TestHashedKey(t *testing.T) {
+	for name, h := range map[string]Hasher[int]{"Hasher": newIntHasher(), "Collisions": levelHasher{}} {
+		t.Run(name, func(t *testing.T) {
+			hasher := &countingHasher[int]{Hasher: h}
+			m1 := NewPersistentHAMT[int, int](hasher)
+			for i := 0; i < 1000; i++ {
+				m1.Set(i, i)
+			}
+			m2 := m1.Clone()
+			m2.Set(5, -5)
+
+			keys := make([]*HashedKey[int], 1000)
+			for i := range keys {
+				keys[i] = m1.HashKey(i)
+			}
+
+			hasher.hashCount, hasher.rehashCount = 0, 0
+			for i, hk := range keys {
+				if v, ok := m1.GetHashed(hk); !ok || v != i {
+					t.Fatalf("m1.GetHashed(%d) = %d, %v", i, v, ok)
+				}
+				want := i
+				if i == 5 {
+					want = -5
+				}
+				if v, ok := m2.GetHashed(hk); !ok || v != want {
+					t.Fatalf("m2.GetHashed(%d) = %d, %v, want %d", i, v, ok, want)
+				}
+			}
+			rehashes := hasher.rehashCount
+
+			for i, hk := range keys {
+				m2.SetHashed(hk, i*2)
+				if i%3 == 0 && !m2.DeleteHashed(hk) {
+					t.Fatalf("DeleteHashed(%d) should delete the key", i)
+				}
+			}
+
+			if hasher.hashCount != 0 {
+				t.Errorf("the hashed keys were hashed %d times", hasher.hashCount)
+			}
+			if hasher.rehashCount != rehashes {
+				t.Errorf("the rehashes of the hashed keys were computed %d times, then %d more times", rehashes, hasher.rehashCount-rehashes)
+			}
+			if name == "Collisions" && rehashes == 0 {
+				t.Errorf("the keys should need a rehash")
+			}
+
+			for i := 0; i < 1000; i++ {
+				v, ok := m2.Get(i)
+				if ok != (i%3 != 0) || (ok && v != i*2) {
+					t.Fatalf("Get(%d) = %d, %v after SetHashed and DeleteHashed", i, v, ok)
+				}
+				if v, _ := m1.Get(i); v != i {
+					t.Fatalf("changing a clone should not change the original")
+				}
+			}
+			if m2.Len() != 666 {
+				t.Errorf("Len() = %d, want 666", m2.Len())
+			}
+			validateNode(t, m2.root)
+		})
+	}
+
+	t.Run("OtherHasher", func(t *testing.T) {
+		m := NewPersistentHAMT[int, int](newIntHasher())
+		m.Set(1, 1)
+
+		// keys hashed by another hasher are hashed again by the map
+		hk := NewHashedKey[int](levelHasher{}, 1)
+		if v, ok := m.GetHashed(hk); !ok || v != 1 {
+			t.Errorf("GetHashed() = %d, %v, want 1, true", v, ok)
+		}
+		m.SetHashed(NewHashedKey[int](NewDefaultHasher[int](), 2), 2)
+		if v, ok := m.Get(2); !ok || v != 2 {
+			t.Errorf("Get() = %d, %v after SetHashed, want 2, true", v, ok)
+		}
+		if !m.DeleteHashed(hk) || m.Len() != 1 {
+			t.Errorf("DeleteHashed() should delete the key")
+		}
+	})
+}
+
+func BenchmarkHashedKey(b *testing.B) {
+	m := NewDefaultHasher[string]()
+	trie := NewPersistentHAMT[string, int](m)
+	key := fmt.Sprintf("%01024d", 42)
+	trie.Set(key, 42)
+	hk := trie.HashKey(key)
+
+	b.Run("Get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			trie.Get(key)
+		}
+	})
+
+	b.Run("GetHashed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			trie.GetHashed(hk)
+		}
+	})
+}
@@ -0,0 +1,272 @@
+package hamt
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"math/rand"
+	"reflect"
+	"unsafe"
+
+	"github.com/cespare/xxhash"
+)
+
+// defaultHasher hashes any comparable key from a byte representation of its value.
+// Both use xxhash; Rehash hashes the number of previous hashes before the key, so the hashes of the different levels
+// are independent of each other.
+type defaultHasher[K comparable] struct{}
+
+// NewDefaultHasher returns the Hasher used when none is given, e.g. when a map is decoded into a zero value.
+// It works with every comparable key type. A dedicated Hasher for the key type is usually faster.
+// As in a Go map, a NaN key is never equal to itself: every Set of a NaN adds a new entry, which Get can't find but
+// Range visits. NaN keys get a random hash, so they don't all collide at every level of the trie.
+func NewDefaultHasher[K comparable]() Hasher[K] {
+	return defaultHasher[K]{}
+}
+
+func (defaultHasher[K]) Hash(key K) uint64 {
+	if s, ok := any(key).(string); ok {
+		return xxhash.Sum64String(s)
+	}
+
+	var buf [keyBufSize]byte
+	return xxhash.Sum64(appendKeyBytes(buf[:0], key))
+}
+
+func (defaultHasher[K]) Rehash(key K, prevHashCount int) uint64 {
+	var buf [keyBufSize]byte
+	buf[0] = byte(prevHashCount)
+	return xxhash.Sum64(appendKeyBytes(buf[:1], key))
+}
+
+// seededHasher hashes any comparable key like defaultHasher, but mixes a random seed into every hash, so the layout
+// of a trie can't be predicted without knowing the seed. The level of a hash is hashed along with the key, so the
+// hashes of the different levels are independent of each other too.
+type seededHasher[K comparable] struct {
+	seed maphash.Seed
+}
+
+// NewSeededHasher returns a Hasher for any comparable key type with a new random seed. Keys crafted to collide
+// under one seed don't collide under another one, which protects maps filled with untrusted keys against
+// hash flooding. NaN keys get a random hash, see NewDefaultHasher.
+// Maps using different seeds don't hash their keys the same way: maps that are compared or merged must share the
+// same hasher, e.g. by being cloned from each other.
+func NewSeededHasher[K comparable]() Hasher[K] {
+	return seededHasher[K]{seed: maphash.MakeSeed()}
+}
+
+// NewSeededPersistentHAMT returns an empty map using a hasher with its own random seed. Clones of the map keep the
+// seed.
+func NewSeededPersistentHAMT[K comparable, V any]() *PersistentHAMT[K, V] {
+	return NewPersistentHAMT[K, V](NewSeededHasher[K]())
+}
+
+// NewSeededPersistentSet returns an empty set using a hasher with its own random seed. Clones of the set keep the
+// seed.
+func NewSeededPersistentSet[K comparable]() *PersistentSet[K] {
+	return NewPersistentSet[K](NewSeededHasher[K]())
+}
+
+func (h seededHasher[K]) Hash(key K) uint64 {
+	return h.Rehash(key, 0)
+}
+
+func (h seededHasher[K]) Rehash(key K, prevHashCount int) uint64 {
+	var mh maphash.Hash
+	mh.SetSeed(h.seed)
+	mh.WriteByte(byte(prevHashCount))
+
+	if s, ok := any(key).(string); ok {
+		mh.WriteString(s)
+	} else {
+		var buf [keyBufSize]byte
+		mh.Write(appendKeyBytes(buf[:0], key))
+	}
+	return mh.Sum64()
+}
+
+// seeded wraps a Hasher: the first level uses the hash of h mixed with a random seed, the deeper levels are hashed
+// from the seed and the key by the embedded seededHasher.
+type seeded[K comparable] struct {
+	h Hasher[K]
+	seededHasher[K]
+}
+
+// Seeded returns a Hasher that mixes a new random seed into the hashes of h, e.g. to give a custom Hasher the
+// per-map seed of NewSeededHasher. The hash of h is hashed again with the seed for the first level of the trie, so
+// keys whose hashes only differ in a few bits end up in unrelated slots. The deeper levels, only reached by keys
+// colliding on the first one, are hashed from the seed and the key like NewSeededHasher does, so keys with the same
+// hashes under h are told apart there.
+// Like NewSeededHasher, maps that are compared or merged must share the same Seeded hasher.
+func Seeded[K comparable](h Hasher[K]) Hasher[K] {
+	return seeded[K]{h: h, seededHasher: seededHasher[K]{seed: maphash.MakeSeed()}}
+}
+
+func (s seeded[K]) Hash(key K) uint64 {
+	return s.mix(s.h.Hash(key))
+}
+
+func (s seeded[K]) mix(hash uint64) uint64 {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], hash)
+
+	var mh maphash.Hash
+	mh.SetSeed(s.seed)
+	mh.Write(buf[:])
+	return mh.Sum64()
+}
+
+// keyBufSize is the size of the buffer on the stack the hashers write a key to. Only keys with a longer byte
+// representation are written to the heap.
+const keyBufSize = 64
+
+// appendKeyBytes appends a byte representation of the key to buf. Equal keys always have the same representation.
+// NaN floats are never equal, not even to themselves, and are written as random bytes instead.
+// The key is read in place, so hashing doesn't allocate unless the key holds an interface.
+func appendKeyBytes[K comparable](buf []byte, key K) []byte {
+	// switching on a pointer to the key doesn't box the key
+	switch k := any(&key).(type) {
+	case *string:
+		return append(buf, *k...)
+	case *int:
+		return binary.LittleEndian.AppendUint64(buf, uint64(*k))
+	case *int64:
+		return binary.LittleEndian.AppendUint64(buf, uint64(*k))
+	case *uint64:
+		return binary.LittleEndian.AppendUint64(buf, *k)
+	case *int32:
+		return binary.LittleEndian.AppendUint32(buf, uint32(*k))
+	case *uint32:
+		return binary.LittleEndian.AppendUint32(buf, *k)
+	case *float64:
+		return appendFloatBytes(buf, *k)
+	}
+
+	if t := reflect.TypeOf((*K)(nil)).Elem(); !holdsInterface(t) {
+		return appendMemBytes(buf, t, unsafe.Pointer(&key))
+	}
+	return appendInterfaceKeyBytes(buf, key)
+}
+
+// appendInterfaceKeyBytes is appendKeyBytes for keys holding an interface. The values held by interfaces are reached
+// through reflect.Value, which moves the key to the heap, so it gets its own copy of the key.
+func appendInterfaceKeyBytes[K comparable](buf []byte, key K) []byte {
+	return appendValueBytes(buf, reflect.ValueOf(&key).Elem())
+}
+
+// holdsInterface reports whether a value of type t holds an interface.
+func holdsInterface(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Array:
+		return holdsInterface(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if holdsInterface(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// appendValueBytes appends the byte representation of the addressable value v, which may hold interfaces.
+func appendValueBytes(buf []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			buf = appendValueBytes(buf, v.Index(i))
+		}
+		return buf
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			buf = appendValueBytes(buf, v.Field(i))
+		}
+		return buf
+	case reflect.Interface:
+		if v.IsNil() {
+			return append(buf, 0)
+		}
+		// the value held by the interface can't be addressed, a copy of it can
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		buf = append(buf, elem.Type().String()...)
+		return appendValueBytes(buf, elem)
+	}
+
+	return appendMemBytes(buf, v.Type(), unsafe.Pointer(v.UnsafeAddr()))
+}
+
+// appendMemBytes appends the byte representation of the value of type t stored at p. The value must not hold an
+// interface.
+func appendMemBytes(buf []byte, t reflect.Type, p unsafe.Pointer) []byte {
+	switch t.Kind() {
+	case reflect.Bool:
+		if *(*bool)(p) {
+			return append(buf, 1)
+		}
+		return append(buf, 0)
+	case reflect.Int:
+		return binary.LittleEndian.AppendUint64(buf, uint64(*(*int)(p)))
+	case reflect.Int8:
+		return binary.LittleEndian.AppendUint64(buf, uint64(*(*int8)(p)))
+	case reflect.Int16:
+		return binary.LittleEndian.AppendUint64(buf, uint64(*(*int16)(p)))
+	case reflect.Int32:
+		return binary.LittleEndian.AppendUint64(buf, uint64(*(*int32)(p)))
+	case reflect.Int64:
+		return binary.LittleEndian.AppendUint64(buf, uint64(*(*int64)(p)))
+	case reflect.Uint:
+		return binary.LittleEndian.AppendUint64(buf, uint64(*(*uint)(p)))
+	case reflect.Uint8:
+		return binary.LittleEndian.AppendUint64(buf, uint64(*(*uint8)(p)))
+	case reflect.Uint16:
+		return binary.LittleEndian.AppendUint64(buf, uint64(*(*uint16)(p)))
+	case reflect.Uint32:
+		return binary.LittleEndian.AppendUint64(buf, uint64(*(*uint32)(p)))
+	case reflect.Uint64:
+		return binary.LittleEndian.AppendUint64(buf, *(*uint64)(p))
+	case reflect.Uintptr, reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		return binary.LittleEndian.AppendUint64(buf, uint64(*(*uintptr)(p)))
+	case reflect.Float32:
+		return appendFloatBytes(buf, float64(*(*float32)(p)))
+	case reflect.Float64:
+		return appendFloatBytes(buf, *(*float64)(p))
+	case reflect.Complex64:
+		c := *(*complex64)(p)
+		return appendFloatBytes(appendFloatBytes(buf, float64(real(c))), float64(imag(c)))
+	case reflect.Complex128:
+		c := *(*complex128)(p)
+		return appendFloatBytes(appendFloatBytes(buf, real(c)), imag(c))
+	case reflect.String:
+		// length prefixed, so the fields of a struct can't run into each other
+		s := *(*string)(p)
+		buf = binary.AppendUvarint(buf, uint64(len(s)))
+		return append(buf, s...)
+	case reflect.Array:
+		for i := 0; i < t.Len(); i++ {
+			buf = appendMemBytes(buf, t.Elem(), unsafe.Add(p, uintptr(i)*t.Elem().Size()))
+		}
+		return buf
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			buf = appendMemBytes(buf, f.Type, unsafe.Add(p, f.Offset))
+		}
+		return buf
+	}
+
+	panic("hamt: unsupported key kind " + t.Kind().String())
+}
+
+func appendFloatBytes(buf []byte, f float64) []byte {
+	if f != f {
+		// NaN != NaN: a random hash spreads NaN keys over the trie, as there is no way to find them again anyway
+		return binary.LittleEndian.AppendUint64(buf, rand.Uint64())
+	}
+	if f == 0 {
+		f = 0 // -0 == +0
+	}
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f))
+}
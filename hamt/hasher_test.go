@@ -0,0 +1,157 @@
+package hamt
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSeededHasher(t *testing.T) {
+	t.Run("Collisions", func(t *testing.T) {
+		victim := NewSeededPersistentHAMT[string, int]()
+		other := NewSeededPersistentHAMT[string, int]()
+
+		// keys crafted to share the first two levels of the victim's trie
+		const mask = 1<<(2*arity) - 1
+		var crafted []string
+		for i := 0; len(crafted) < 50; i++ {
+			k := fmt.Sprint("key-", i)
+			if victim.hasher.Hash(k)&mask == 0 {
+				crafted = append(crafted, k)
+			}
+		}
+
+		for i, k := range crafted {
+			victim.Set(k, i)
+			other.Set(k, i)
+		}
+
+		if got := victim.Stats().RecordsPerDepth; len(got) < 3 || got[0] != 0 || got[1] != 0 {
+			t.Fatalf("the crafted keys should collide in the victim, RecordsPerDepth = %v", got)
+		}
+
+		spread := make(map[uint64]bool)
+		for _, k := range crafted {
+			spread[other.hasher.Hash(k)&mask] = true
+		}
+		if len(spread) < len(crafted)/2 {
+			t.Errorf("the crafted keys share %d prefixes in another map, want about %d", len(spread), len(crafted))
+		}
+		if s := other.Stats(); s.RecordsPerDepth[0]+s.RecordsPerDepth[1] < len(crafted)/2 {
+			t.Errorf("the crafted keys should not collide in another map, RecordsPerDepth = %v", s.RecordsPerDepth)
+		}
+	})
+
+	t.Run("Levels", func(t *testing.T) {
+		h := NewSeededHasher[int]()
+		for k := 0; k < 100; k++ {
+			seen := map[uint64]bool{h.Hash(k): true}
+			for level := 1; level <= maxDepth/(exhaustedLevel+1); level++ {
+				hash := h.Rehash(k, level)
+				if seen[hash] {
+					t.Fatalf("Rehash(%d, %d) repeats the hash of another level", k, level)
+				}
+				seen[hash] = true
+			}
+		}
+
+		if a, b := h.Hash(42), h.Hash(42); a != b {
+			t.Errorf("Hash(42) = %x, then %x", a, b)
+		}
+		if NewSeededHasher[int]().Hash(42) == h.Hash(42) {
+			t.Errorf("two seeded hashers should not hash a key the same way")
+		}
+	})
+
+	t.Run("Clone", func(t *testing.T) {
+		m := NewSeededPersistentHAMT[int, int]()
+		for i := 0; i < 1000; i++ {
+			m.Set(i, i)
+		}
+
+		clone := m.Clone()
+		clone.Set(1000, 1000)
+		if clone.hasher != m.hasher {
+			t.Fatalf("Clone() should keep the seed of the map")
+		}
+
+		merged := Merge(m, clone, nil)
+		if merged.Len() != 1001 || !Equal(merged, clone, intEq) {
+			t.Errorf("maps cloned from each other should hash their keys the same way")
+		}
+
+		s := NewSeededPersistentSet[int]()
+		s.Add(1)
+		if s.Clone().hasher != s.hasher {
+			t.Errorf("Clone() should keep the seed of the set")
+		}
+	})
+}
+
+// identityHasher uses the key as its hash, so keys sharing their low bits collide on the first levels of the trie.
+type identityHasher struct{}
+
+func (identityHasher) Hash(key int) uint64 {
+	return uint64(key)
+}
+
+func (identityHasher) Rehash(key int, prevHashCount int) uint64 {
+	return uint64(key) ^ uint64(prevHashCount)<<(2*arity)
+}
+
+func TestSeeded(t *testing.T) {
+	plain := NewPersistentHAMT[int, int](identityHasher{})
+	seeded := NewPersistentHAMT[int, int](Seeded[int](identityHasher{}))
+
+	// the first two levels of every key are 0 under identityHasher
+	for i := 0; i < 200; i++ {
+		plain.Set(i<<(2*arity), i)
+		seeded.Set(i<<(2*arity), i)
+	}
+
+	if got := plain.Stats().RecordsPerDepth; got[0] != 0 || got[1] != 0 {
+		t.Fatalf("the keys should collide under identityHasher, RecordsPerDepth = %v", got)
+	}
+	if s := seeded.Stats(); s.RecordsPerDepth[0]+s.RecordsPerDepth[1] < 100 {
+		t.Errorf("the keys should not collide once seeded, RecordsPerDepth = %v", s.RecordsPerDepth)
+	}
+	assertSameMap(t, seeded.ToMap(), plain.ToMap())
+
+	h := Seeded[int](identityHasher{})
+	if h.Hash(42) != h.Hash(42) || h.Rehash(42, 1) != h.Rehash(42, 1) {
+		t.Errorf("a seeded hasher should hash a key the same way every time")
+	}
+	if h.Hash(42) == h.Rehash(42, 1) {
+		t.Errorf("a seeded hasher should keep the levels of the wrapped hasher apart")
+	}
+	if Seeded[int](identityHasher{}).Hash(42) == h.Hash(42) {
+		t.Errorf("two seeded hashers should not hash a key the same way")
+	}
+
+	t.Run("Full collisions", func(t *testing.T) {
+		// every key has the same hashes at every level under constHasher, a plain map can't hold two of them
+		m := NewPersistentHAMT[int, int](Seeded[int](constHasher{}))
+		for i := 0; i < 1000; i++ {
+			m.Set(i, i)
+		}
+
+		if m.Len() != 1000 {
+			t.Fatalf("Len() = %d, want 1000", m.Len())
+		}
+		for i := 0; i < 1000; i++ {
+			if v, ok := m.Get(i); !ok || v != i {
+				t.Fatalf("Get(%d) = %d, %v", i, v, ok)
+			}
+		}
+	})
+}
+
+// constHasher gives every key the same hashes.
+type constHasher struct{}
+
+func (constHasher) Hash(key int) uint64 {
+	return 0
+}
+
+func (constHasher) Rehash(key int, prevHashCount int) uint64 {
+	return 0
+}
@@ -3,6 +3,7 @@ package hamt
 import (
 	crand "crypto/rand"
 	"fmt"
+
 	"github.com/cespare/xxhash"
 	"github.com/spaolacci/murmur3"
 )
@@ -159,3 +160,20 @@ func (hs *intHasher) Rehash(key int, level int) uint64 {
 
 	return xxhash.Sum64String(s)
 }
+
+// countingHasher counts the calls to Hash and Rehash of the wrapped hasher.
+type countingHasher[K comparable] struct {
+	Hasher[K]
+	hashCount   int
+	rehashCount int
+}
+
+func (hs *countingHasher[K]) Hash(key K) uint64 {
+	hs.hashCount++
+	return hs.Hasher.Hash(key)
+}
+
+func (hs *countingHasher[K]) Rehash(key K, level int) uint64 {
+	hs.rehashCount++
+	return hs.Hasher.Rehash(key, level)
+}
@@ -0,0 +1,225 @@
+package hamt
+
+import (
+	"math/bits"
+	"testing"
+	"unsafe"
+)
+
+// legacyWidth is the number of content slots of a used slot in legacyNode: a record and a sub-node.
+const legacyWidth = 2
+
+// legacyNode is the layout of the nodes before the CHAMP split: a single bitmap, and for every used slot a
+// (record, sub-node) pair interleaved in one []unsafe.Pointer, where only one of the two is set.
+// It only exists to compare both layouts in BenchmarkNodeLayout.
+type legacyNode[K comparable, V any] struct {
+	bitmap   uint64
+	refCount int32
+
+	contentArray []unsafe.Pointer // *record in even slots, *legacyNode in odd slots
+}
+
+func toLegacyNode[K comparable, V any](n *trieNode[K, *record[K, V]]) *legacyNode[K, V] {
+	l := &legacyNode[K, V]{bitmap: n.dataMap | n.nodeMap, refCount: 1}
+	l.contentArray = make([]unsafe.Pointer, 0, legacyWidth*bits.OnesCount64(l.bitmap))
+
+	for used := l.bitmap; used != 0; used &= used - 1 {
+		var mask uint64 = 1 << bits.TrailingZeros64(used)
+
+		if n.dataMap&mask != 0 {
+			l.contentArray = append(l.contentArray, unsafe.Pointer(n.entries[n.entryIndex(mask)]), nil)
+		} else {
+			l.contentArray = append(l.contentArray, nil, unsafe.Pointer(toLegacyNode(n.nodes[n.nodeIndex(mask)])))
+		}
+	}
+	return l
+}
+
+func (l *legacyNode[K, V]) incRef() *legacyNode[K, V] {
+	if l != nil {
+		l.refCount++
+	}
+	return l
+}
+
+// clone is the shallowCloneWithRef of the legacy layout, without releasing l.
+func (l *legacyNode[K, V]) clone() *legacyNode[K, V] {
+	l1 := &legacyNode[K, V]{bitmap: l.bitmap, refCount: 1}
+
+	l1.contentArray = make([]unsafe.Pointer, len(l.contentArray))
+	for i := 0; i < len(l.contentArray)/legacyWidth; i++ {
+		recordIdx := i * legacyWidth
+		nodeIdx := recordIdx + 1
+
+		l1.contentArray[recordIdx] = unsafe.Pointer((*record[K, V])(l.contentArray[recordIdx]).incRef())
+		l1.contentArray[nodeIdx] = unsafe.Pointer((*legacyNode[K, V])(l.contentArray[nodeIdx]).incRef())
+	}
+	return l1
+}
+
+func (l *legacyNode[K, V]) get(h Hasher[K], k K, keyHash uint64) (_ V, _ bool) {
+	for depth := 0; ; depth++ {
+		mask := slotMask(keyHash, depth)
+		if l.bitmap&mask == 0 {
+			return
+		}
+
+		recordIdx := legacyWidth * bits.OnesCount64(l.bitmap&(mask-1))
+		if r := (*record[K, V])(l.contentArray[recordIdx]); r != nil {
+			if r.key == k {
+				return r.value, true
+			}
+			return
+		}
+		l = (*legacyNode[K, V])(l.contentArray[recordIdx+1])
+
+		if depth%(exhaustedLevel+1) == exhaustedLevel {
+			keyHash = hashAt(h, k, depth+1)
+		}
+	}
+}
+
+func (l *legacyNode[K, V]) rangeAll(f func(k K, v V)) {
+	for i := 0; i < len(l.contentArray)/legacyWidth; i++ {
+		if r := (*record[K, V])(l.contentArray[legacyWidth*i]); r != nil {
+			f(r.key, r.value)
+		}
+		if child := (*legacyNode[K, V])(l.contentArray[legacyWidth*i+1]); child != nil {
+			child.rangeAll(f)
+		}
+	}
+}
+
+// nodeBytes returns the number of nodes under l and the bytes they take: the struct and the backing array of its
+// slice, before the rounding of the allocator.
+func (l *legacyNode[K, V]) nodeBytes() (nodes, bytes int) {
+	nodes, bytes = 1, int(unsafe.Sizeof(*l))+cap(l.contentArray)*int(unsafe.Sizeof(unsafe.Pointer(nil)))
+	for i := 1; i < len(l.contentArray); i += legacyWidth {
+		if child := (*legacyNode[K, V])(l.contentArray[i]); child != nil {
+			n, b := child.nodeBytes()
+			nodes, bytes = nodes+n, bytes+b
+		}
+	}
+	return
+}
+
+// copySplitNode deep copies the sub-trie of n the way toLegacyNode does, so both layouts are allocated in the same
+// order in the benchmark.
+func copySplitNode[K comparable, V any](n *trieNode[K, *record[K, V]]) *trieNode[K, *record[K, V]] {
+	n1 := &trieNode[K, *record[K, V]]{dataMap: n.dataMap, nodeMap: n.nodeMap, refCount: 1, size: n.size}
+	n1.entries = append([]*record[K, V](nil), n.entries...)
+	n1.nodes = make([]*trieNode[K, *record[K, V]], len(n.nodes))
+	for i, child := range n.nodes {
+		n1.nodes[i] = copySplitNode(child)
+	}
+	return n1
+}
+
+func rangeAll[K comparable, V any](n *trieNode[K, *record[K, V]], f func(k K, v V)) {
+	for _, r := range n.entries {
+		f(r.key, r.value)
+	}
+	for _, child := range n.nodes {
+		rangeAll(child, f)
+	}
+}
+
+// splitNodeBytes returns the number of nodes under n and the bytes they take, counted like legacyNode.nodeBytes.
+func splitNodeBytes[K comparable, E entry[K, E]](n *trieNode[K, E]) (nodes, bytes int) {
+	var e E
+	nodes = 1
+	bytes = int(unsafe.Sizeof(*n)) + cap(n.entries)*int(unsafe.Sizeof(e)) + cap(n.nodes)*int(unsafe.Sizeof(n))
+	for _, child := range n.nodes {
+		c, b := splitNodeBytes(child)
+		nodes, bytes = nodes+c, bytes+b
+	}
+	return
+}
+
+func TestLegacyNode(t *testing.T) {
+	trie, keys := benchmarkMap(5000)
+	legacy := toLegacyNode(trie.root)
+
+	for _, k := range keys {
+		want, _ := trie.Get(k)
+		if v, ok := legacy.get(trie.hasher, k, trie.hash(k, 0)); !ok || v != want {
+			t.Fatalf("get(%d) = %d, %v, want %d", k, v, ok, want)
+		}
+	}
+	if _, ok := legacy.get(trie.hasher, -1, trie.hash(-1, 0)); ok {
+		t.Errorf("get(-1) found a missing key")
+	}
+
+	count := 0
+	legacy.rangeAll(func(k, v int) { count++ })
+	if count != trie.Len() {
+		t.Errorf("rangeAll() visited %d records, want %d", count, trie.Len())
+	}
+
+	if nodes, _ := legacy.nodeBytes(); nodes != trie.Stats().Nodes {
+		t.Errorf("nodeBytes() counted %d nodes, want %d", nodes, trie.Stats().Nodes)
+	}
+}
+
+// BenchmarkNodeLayout compares the CHAMP layout of trieNode with the legacy layout of interleaved (record, sub-node)
+// pairs in a []unsafe.Pointer, on the same trie. Every run reports the average bytes per node of its layout, which
+// include the size and debug fields of trieNode. Keys are hashed before the runs, so Get only measures the walk.
+// Run it with: go test -run NONE -bench NodeLayout -benchmem ./hamt
+func BenchmarkNodeLayout(b *testing.B) {
+	trie, keys := benchmarkMap(100000)
+	trie.root = copySplitNode(trie.root)
+	legacy := toLegacyNode(trie.root)
+
+	hashes := make([]uint64, len(keys))
+	for i, k := range keys {
+		hashes[i] = trie.hash(k, 0)
+	}
+
+	splitNodes, splitBytes := splitNodeBytes(trie.root)
+	legacyNodes, legacyBytes := legacy.nodeBytes()
+	run := func(name string, nodes, bytes int, f func(b *testing.B)) {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			f(b)
+			b.ReportMetric(float64(bytes)/float64(nodes), "B/node")
+		})
+	}
+
+	run("Get/split", splitNodes, splitBytes, func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			j := i % len(keys)
+			trie.getEntry(trie.root, keys[j], hashes[j], nil, 0)
+		}
+	})
+	run("Get/legacy", legacyNodes, legacyBytes, func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			j := i % len(keys)
+			legacy.get(trie.hasher, keys[j], hashes[j])
+		}
+	})
+
+	run("Range/split", splitNodes, splitBytes, func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sum := 0
+			rangeAll(trie.root, func(k, v int) { sum += v })
+		}
+	})
+	run("Range/legacy", legacyNodes, legacyBytes, func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sum := 0
+			legacy.rangeAll(func(k, v int) { sum += v })
+		}
+	})
+
+	// cloning the root is the first step of every write to a shared trie
+	run("Clone/split", splitNodes, splitBytes, func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			trie.root.clone()
+		}
+	})
+	run("Clone/legacy", legacyNodes, legacyBytes, func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			legacy.clone()
+		}
+	})
+}
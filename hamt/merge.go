@@ -0,0 +1,299 @@
+package hamt
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// Merge returns a new map holding the entries of both a and b. If a key exists in both maps, its value in the
+// result is resolve(k, va, vb), or vb if resolve is nil.
+// The two tries are merged node by node: a sub-trie that only exists in one of the maps is shared with the result as
+// a whole instead of being inserted one entry at a time. When resolve is nil, sub-tries shared by a and b are reused
+// too. Both maps must hash their keys the same way; the result uses the hasher of a.
+// Values produced by resolve are stored without a release callback.
+func Merge[K comparable, V any](a, b *PersistentHAMT[K, V], resolve func(k K, va, vb V) V) *PersistentHAMT[K, V] {
+	a.thaw()
+	b.thaw()
+	m := &PersistentHAMT[K, V]{}
+	m.hasher = a.hasher
+
+	common := 0
+	m.root = m.union(nodeOrEmpty(a.root), nodeOrEmpty(b.root), 0, resolveRecords(resolve), &common)
+	m.len = a.Len() + b.Len() - common
+	return m
+}
+
+// Intersect returns a new map holding the keys that exist in both a and b. The value of a key in the result is
+// resolve(k, va, vb), or va if resolve is nil.
+// Like Merge, Intersect works node by node and, when resolve is nil, reuses the sub-tries shared by a and b.
+// Both maps must hash their keys the same way; the result uses the hasher of a.
+func Intersect[K comparable, V any](a, b *PersistentHAMT[K, V], resolve func(k K, va, vb V) V) *PersistentHAMT[K, V] {
+	a.thaw()
+	b.thaw()
+	m := &PersistentHAMT[K, V]{}
+	m.hasher = a.hasher
+
+	common := 0
+	m.root = m.intersect(nodeOrEmpty(a.root), nodeOrEmpty(b.root), 0, resolveRecords(resolve), &common)
+	m.len = common
+	return m
+}
+
+// Subtract returns a new map holding the entries of a whose keys don't exist in b.
+// Sub-tries of a with no counterpart in b are shared with the result as a whole, and sub-tries shared by a and b are
+// dropped without being visited. Both maps must hash their keys the same way; the result uses the hasher of a.
+func Subtract[K comparable, V any](a, b *PersistentHAMT[K, V]) *PersistentHAMT[K, V] {
+	a.thaw()
+	b.thaw()
+	m := &PersistentHAMT[K, V]{}
+	m.hasher = a.hasher
+
+	removed := 0
+	m.root = m.subtract(nodeOrEmpty(a.root), nodeOrEmpty(b.root), 0, &removed)
+	m.len = a.Len() - removed
+	return m
+}
+
+// resolveRecords turns the resolve function of Merge and Intersect into one combining two records of the same key
+// into a new record. It returns nil if resolve is nil.
+func resolveRecords[K comparable, V any](resolve func(k K, va, vb V) V) func(r1, r2 *record[K, V]) *record[K, V] {
+	if resolve == nil {
+		return nil
+	}
+
+	return func(r1, r2 *record[K, V]) *record[K, V] {
+		return newRecord[K, V](r1.key, resolve(r1.key, r1.value, r2.value), nil)
+	}
+}
+
+func nodeOrEmpty[K comparable, E entry[K, E]](n *trieNode[K, E]) *trieNode[K, E] {
+	if n == nil {
+		return &trieNode[K, E]{}
+	}
+	return n
+}
+
+// singleton creates a transient node holding only the entry e at the given depth, so an entry can be combined
+// with a sub-node of the other trie in the same way as two sub-nodes. The node is not owned by any version (its
+// refCount is 0) and the entry is not referenced by it.
+func (t *trie[K, E]) singleton(e E, depth int) *trieNode[K, E] {
+	level := depth % (exhaustedLevel + 1)
+	shift := level * arity
+	loc := bucket(t.hash(e.entryKey(), depth), shift)
+
+	return &trieNode[K, E]{
+		dataMap: 1 << loc,
+		size:    1,
+		entries: []E{e},
+	}
+}
+
+// appendEntry adds the entry e in the slot of mask to a node under construction.
+// Slots must be appended in increasing order.
+func (n *trieNode[K, E]) appendEntry(mask uint64, e E) {
+	n.dataMap |= mask
+	n.entries = append(n.entries, e)
+	n.size++
+}
+
+// appendNode adds the sub-node child in the slot of mask to a node under construction. A nil child is ignored and
+// a child holding a single entry is inlined, so the built node stays canonical.
+// Slots must be appended in increasing order.
+func (n *trieNode[K, E]) appendNode(mask uint64, child *trieNode[K, E]) {
+	if child == nil {
+		return
+	}
+
+	if e, ok := child.singleEntry(); ok {
+		n.appendEntry(mask, e.incRef())
+		child.decRef()
+		return
+	}
+
+	n.nodeMap |= mask
+	n.nodes = append(n.nodes, child)
+	n.size += child.size
+}
+
+// sameContent reports whether n and n1 have the same bitmaps and hold the same entries and sub-nodes.
+func (n *trieNode[K, E]) sameContent(n1 *trieNode[K, E]) bool {
+	if n.dataMap != n1.dataMap || n.nodeMap != n1.nodeMap {
+		return false
+	}
+
+	for i, e := range n.entries {
+		if e != n1.entries[i] {
+			return false
+		}
+	}
+
+	for i, child := range n.nodes {
+		if child != n1.nodes[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reuse returns one of the candidates with a new reference if it has the same content as the freshly built node n,
+// so unchanged sub-tries stay shared with the input tries. Otherwise n is returned.
+func reuse[K comparable, E entry[K, E]](n *trieNode[K, E], candidates ...*trieNode[K, E]) *trieNode[K, E] {
+	for _, c := range candidates {
+		if atomic.LoadInt32(&c.refCount) > 0 && n.sameContent(c) {
+			n.decRef()
+			return c.incRef()
+		}
+	}
+
+	return n
+}
+
+// union merges n1 and n2 found at the given depth. A key found in both is stored with resolve(e1, e2), or e2 if
+// resolve is nil. common is increased by the number of keys found in both.
+func (t *trie[K, E]) union(n1, n2 *trieNode[K, E], depth int, resolve func(e1, e2 E) E, common *int) *trieNode[K, E] {
+	if n1 == n2 && resolve == nil {
+		*common += n1.size
+		return n1.incRef()
+	}
+
+	n := newTrieNode[K, E]()
+
+	for used := n1.dataMap | n1.nodeMap | n2.dataMap | n2.nodeMap; used != 0; used &= used - 1 {
+		var mask uint64 = 1 << bits.TrailingZeros64(used)
+
+		switch {
+		case n1.dataMap&mask != 0 && n2.dataMap&mask != 0:
+			e1, e2 := n1.entries[n1.entryIndex(mask)], n2.entries[n2.entryIndex(mask)]
+			k1, k2 := e1.entryKey(), e2.entryKey()
+			if k1 == k2 {
+				*common++
+				if resolve == nil {
+					n.appendEntry(mask, e2.incRef())
+				} else {
+					n.appendEntry(mask, resolve(e1, e2))
+				}
+				break
+			}
+
+			n.appendNode(mask, t.mergeEntries(t.hash(k1, depth+1), e1.incRef(), t.hash(k2, depth+1), e2.incRef(), depth+1))
+		case n1.dataMap&mask != 0 && n2.nodeMap&mask != 0:
+			n.appendNode(mask, t.union(t.singleton(n1.entries[n1.entryIndex(mask)], depth+1), n2.nodes[n2.nodeIndex(mask)], depth+1, resolve, common))
+		case n1.nodeMap&mask != 0 && n2.dataMap&mask != 0:
+			n.appendNode(mask, t.union(n1.nodes[n1.nodeIndex(mask)], t.singleton(n2.entries[n2.entryIndex(mask)], depth+1), depth+1, resolve, common))
+		case n1.nodeMap&mask != 0 && n2.nodeMap&mask != 0:
+			n.appendNode(mask, t.union(n1.nodes[n1.nodeIndex(mask)], n2.nodes[n2.nodeIndex(mask)], depth+1, resolve, common))
+		case n1.dataMap&mask != 0:
+			n.appendEntry(mask, n1.entries[n1.entryIndex(mask)].incRef())
+		case n1.nodeMap&mask != 0:
+			n.appendNode(mask, n1.nodes[n1.nodeIndex(mask)].incRef())
+		case n2.dataMap&mask != 0:
+			n.appendEntry(mask, n2.entries[n2.entryIndex(mask)].incRef())
+		default:
+			n.appendNode(mask, n2.nodes[n2.nodeIndex(mask)].incRef())
+		}
+	}
+
+	return reuse(n, n1, n2)
+}
+
+// intersect keeps the keys found in both n1 and n2 at the given depth, stored with resolve(e1, e2), or e1 if resolve
+// is nil. common is increased by the number of keys kept.
+func (t *trie[K, E]) intersect(n1, n2 *trieNode[K, E], depth int, resolve func(e1, e2 E) E, common *int) *trieNode[K, E] {
+	if n1 == n2 && resolve == nil {
+		*common += n1.size
+		return n1.incRef()
+	}
+
+	n := newTrieNode[K, E]()
+
+	for used := (n1.dataMap | n1.nodeMap) & (n2.dataMap | n2.nodeMap); used != 0; used &= used - 1 {
+		var mask uint64 = 1 << bits.TrailingZeros64(used)
+
+		var e1, e2 E
+		var found bool
+
+		switch {
+		case n1.nodeMap&mask != 0 && n2.nodeMap&mask != 0:
+			n.appendNode(mask, t.intersect(n1.nodes[n1.nodeIndex(mask)], n2.nodes[n2.nodeIndex(mask)], depth+1, resolve, common))
+			continue
+		case n1.dataMap&mask != 0 && n2.dataMap&mask != 0:
+			e1, e2 = n1.entries[n1.entryIndex(mask)], n2.entries[n2.entryIndex(mask)]
+			found = e1.entryKey() == e2.entryKey()
+		case n1.dataMap&mask != 0:
+			e1 = n1.entries[n1.entryIndex(mask)]
+			k := e1.entryKey()
+			e2, found = t.getEntry(n2.nodes[n2.nodeIndex(mask)], k, t.hash(k, depth+1), nil, depth+1)
+		default:
+			e2 = n2.entries[n2.entryIndex(mask)]
+			k := e2.entryKey()
+			e1, found = t.getEntry(n1.nodes[n1.nodeIndex(mask)], k, t.hash(k, depth+1), nil, depth+1)
+		}
+
+		if !found {
+			continue
+		}
+
+		*common++
+		if resolve == nil {
+			n.appendEntry(mask, e1.incRef())
+		} else {
+			n.appendEntry(mask, resolve(e1, e2))
+		}
+	}
+
+	if n.isEmpty() {
+		n.decRef()
+		return nil
+	}
+
+	return reuse(n, n1, n2)
+}
+
+// subtract keeps the keys of n1 that are not found in n2 at the given depth. removed is increased by the number of
+// keys dropped.
+func (t *trie[K, E]) subtract(n1, n2 *trieNode[K, E], depth int, removed *int) *trieNode[K, E] {
+	if n1 == n2 {
+		*removed += n1.size
+		return nil
+	}
+
+	n := newTrieNode[K, E]()
+
+	for used := n1.dataMap | n1.nodeMap; used != 0; used &= used - 1 {
+		var mask uint64 = 1 << bits.TrailingZeros64(used)
+
+		switch {
+		case n1.dataMap&mask != 0:
+			e1 := n1.entries[n1.entryIndex(mask)]
+			k := e1.entryKey()
+
+			found := false
+			switch {
+			case n2.dataMap&mask != 0:
+				found = n2.entries[n2.entryIndex(mask)].entryKey() == k
+			case n2.nodeMap&mask != 0:
+				_, found = t.getEntry(n2.nodes[n2.nodeIndex(mask)], k, t.hash(k, depth+1), nil, depth+1)
+			}
+
+			if found {
+				*removed++
+				break
+			}
+			n.appendEntry(mask, e1.incRef())
+		case n2.nodeMap&mask != 0:
+			n.appendNode(mask, t.subtract(n1.nodes[n1.nodeIndex(mask)], n2.nodes[n2.nodeIndex(mask)], depth+1, removed))
+		case n2.dataMap&mask != 0:
+			n.appendNode(mask, t.subtract(n1.nodes[n1.nodeIndex(mask)], t.singleton(n2.entries[n2.entryIndex(mask)], depth+1), depth+1, removed))
+		default:
+			n.appendNode(mask, n1.nodes[n1.nodeIndex(mask)].incRef())
+		}
+	}
+
+	if n.isEmpty() {
+		n.decRef()
+		return nil
+	}
+
+	return reuse(n, n1)
+}
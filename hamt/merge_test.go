@@ -0,0 +1,241 @@
+package hamt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func toGoMap[K comparable, V any](m *PersistentHAMT[K, V]) map[K]V {
+	result := make(map[K]V)
+	m.Range(func(k K, v V) bool {
+		result[k] = v
+		return false
+	})
+	return result
+}
+
+func randomMaps(t *testing.T, n int, keyRange int) (*PersistentHAMT[int, int], map[int]int) {
+	t.Helper()
+
+	m := NewPersistentHAMT[int, int](newIntHasher())
+	expected := make(map[int]int)
+	for i := 0; i < n; i++ {
+		k := rand.Intn(keyRange)
+		m.Set(k, i)
+		expected[k] = i
+	}
+	return m, expected
+}
+
+func validateResult(t *testing.T, m *PersistentHAMT[int, int], expected map[int]int) {
+	t.Helper()
+
+	validateNode(t, m.root)
+	if m.Len() != len(expected) {
+		t.Errorf("Len() = %d, want %d", m.Len(), len(expected))
+	}
+	assertSameMap(t, toGoMap(m), expected)
+
+	if !Equal(m, buildMap(expected), intEq) {
+		t.Errorf("the result should have the same shape as a map built by insertion")
+	}
+}
+
+func buildMap(entries map[int]int) *PersistentHAMT[int, int] {
+	m := NewPersistentHAMT[int, int](newIntHasher())
+	for k, v := range entries {
+		m.Set(k, v)
+	}
+	return m
+}
+
+func TestMerge(t *testing.T) {
+	t.Run("Random Merge", func(t *testing.T) {
+		a, ea := randomMaps(t, 3000, 5000)
+		b, eb := randomMaps(t, 3000, 5000)
+
+		expected := make(map[int]int)
+		for k, v := range ea {
+			expected[k] = v
+		}
+		for k, v := range eb {
+			if va, ok := ea[k]; ok {
+				expected[k] = va*10000 + v
+			} else {
+				expected[k] = v
+			}
+		}
+
+		merged := Merge(a, b, func(k int, va, vb int) int { return va*10000 + vb })
+		validateResult(t, merged, expected)
+
+		// inputs are untouched
+		assertSameMap(t, toGoMap(a), ea)
+		assertSameMap(t, toGoMap(b), eb)
+
+		for k, v := range eb {
+			expected[k] = v
+		}
+		validateResult(t, Merge(a, b, nil), expected)
+	})
+
+	t.Run("Merge overrides into defaults", func(t *testing.T) {
+		defaults := NewPersistentHAMT[int, int](newIntHasher())
+		for i := 0; i < 10000; i++ {
+			defaults.Set(i, i)
+		}
+
+		overrides := NewPersistentHAMT[int, int](newIntHasher())
+		overrides.Set(5, 500)
+		overrides.Set(20000, 20000)
+
+		merged := Merge(defaults, overrides, nil)
+		if v, _ := merged.Get(5); v != 500 {
+			t.Errorf("merged.Get(5) = %d, want %d", v, 500)
+		}
+		if v, _ := merged.Get(20000); v != 20000 {
+			t.Errorf("merged.Get(20000) = %d, want %d", v, 20000)
+		}
+		if merged.Len() != 10001 {
+			t.Errorf("merged.Len() = %d, want %d", merged.Len(), 10001)
+		}
+
+		shared := 0
+		for i, child := range merged.root.nodes {
+			if i < len(defaults.root.nodes) && child == defaults.root.nodes[i] {
+				shared++
+			}
+		}
+		if shared < len(defaults.root.nodes)-2 {
+			t.Errorf("untouched sub-tries should be shared with defaults, shared %d of %d", shared, len(defaults.root.nodes))
+		}
+
+		// a map merged with itself is the map itself
+		self := Merge(defaults, defaults, nil)
+		if self.root != defaults.root || self.Len() != defaults.Len() {
+			t.Errorf("merging a map with itself should reuse its root")
+		}
+
+		doubled := Merge(defaults, defaults.Clone(), func(k int, va, vb int) int { return va + vb })
+		if v, _ := doubled.Get(21); v != 42 || doubled.Len() != defaults.Len() {
+			t.Errorf("resolve should be called for shared sub-tries, doubled.Get(21) = %d", v)
+		}
+	})
+}
+
+func TestIntersect(t *testing.T) {
+	a, ea := randomMaps(t, 3000, 5000)
+	b, eb := randomMaps(t, 3000, 5000)
+
+	expected := make(map[int]int)
+	for k, v := range ea {
+		if vb, ok := eb[k]; ok {
+			expected[k] = v - vb
+		}
+	}
+
+	validateResult(t, Intersect(a, b, func(k int, va, vb int) int { return va - vb }), expected)
+
+	for k := range expected {
+		expected[k] = ea[k]
+	}
+	validateResult(t, Intersect(a, b, nil), expected)
+
+	validateResult(t, Intersect(a, NewPersistentHAMT[int, int](newIntHasher()), nil), map[int]int{})
+
+	clone := a.Clone()
+	clone.Set(-1, -1)
+	if self := Intersect(a, clone, nil); self.root != a.root || self.Len() != a.Len() {
+		t.Errorf("intersecting a map with a superset should reuse its root")
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	a, ea := randomMaps(t, 3000, 5000)
+	b, eb := randomMaps(t, 3000, 5000)
+
+	expected := make(map[int]int)
+	for k, v := range ea {
+		if _, ok := eb[k]; !ok {
+			expected[k] = v
+		}
+	}
+	validateResult(t, Subtract(a, b), expected)
+	validateResult(t, Subtract(a, a.Clone()), map[int]int{})
+
+	empty := NewPersistentHAMT[int, int](newIntHasher())
+	if s := Subtract(a, empty); s.root != a.root || s.Len() != a.Len() {
+		t.Errorf("subtracting an empty map should reuse the root")
+	}
+
+	t.Run("Collision", func(t *testing.T) {
+		m := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		for i, k := range []string{"a", "b", "c", "d", "e", "rehash2time_1", "rehash2time_2"} {
+			m.Set(k, i)
+		}
+
+		other := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		other.Set("d", 0)
+		other.Set("rehash2time_2", 0)
+
+		s := Subtract(m, other)
+		expected := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		for i, k := range []string{"a", "b", "c", "e", "rehash2time_1"} {
+			expected.Set(k, []int{0, 1, 2, 4, 5}[i])
+		}
+
+		if !Equal(s, expected, intEq) || !sameShape(t, s.root, expected.root) {
+			t.Errorf("Subtract(m, other) = %v, want %v", toGoMap(s), toGoMap(expected))
+		}
+
+		u := Merge(s, other, nil)
+		if !Equal(u, m, func(v1, v2 int) bool { return true }) || !sameShape(t, u.root, m.root) {
+			t.Errorf("Merge(s, other) = %v, want %v", toGoMap(u), toGoMap(m))
+		}
+	})
+}
+
+func TestMergeRelease(t *testing.T) {
+	released := make(map[int]int)
+	a := NewPersistentHAMT[int, int](newIntHasher())
+	b := NewPersistentHAMT[int, int](newIntHasher())
+	for i := 0; i < 2000; i++ {
+		release := func(k int, v int) { released[k]++ }
+		if i%2 == 0 {
+			a.Put(i, i, release)
+		}
+		if i%3 == 0 {
+			b.Put(i, i, release)
+		}
+	}
+
+	results := []*PersistentHAMT[int, int]{
+		Merge(a, b, nil),
+		Merge(a, b, func(k int, va, vb int) int { return va }),
+		Intersect(a, b, nil),
+		Subtract(a, b),
+		Subtract(b, a),
+	}
+
+	a.Destroy()
+	b.Destroy()
+	if len(released) != 0 {
+		t.Errorf("records still referenced by results should not be released, released %d", len(released))
+	}
+
+	for _, r := range results {
+		r.Destroy()
+	}
+
+	for i := 0; i < 2000; i++ {
+		want := 0
+		if i%2 == 0 && i%3 == 0 {
+			want = 2 // one record in each map
+		} else if i%2 == 0 || i%3 == 0 {
+			want = 1
+		}
+		if released[i] != want {
+			t.Fatalf("record %d released %d times, want %d", i, released[i], want)
+		}
+	}
+}
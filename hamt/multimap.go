@@ -0,0 +1,142 @@
+package hamt
+
+// MultiMap is a persistent map from keys to sets of values.
+// Every key maps to a PersistentSet holding its values, stored in a PersistentHAMT. A set is never changed once
+// stored: adding or removing a value stores a changed clone of the set, so the versions of a MultiMap share the
+// unchanged parts of their sets as well as the unchanged keys. Keys left without values are removed.
+type MultiMap[K, V comparable] struct {
+	sets   *PersistentHAMT[K, *PersistentSet[V]]
+	hasher Hasher[V]
+	len    int
+}
+
+// NewMultiMap returns an empty MultiMap using hk to hash the keys and hv to hash the values.
+func NewMultiMap[K, V comparable](hk Hasher[K], hv Hasher[V]) *MultiMap[K, V] {
+	return &MultiMap[K, V]{
+		sets:   NewPersistentHAMT[K, *PersistentSet[V]](hk),
+		hasher: hv,
+	}
+}
+
+// Len returns the number of key-value pairs of the map.
+func (m *MultiMap[K, V]) Len() int {
+	return m.len
+}
+
+// KeyLen returns the number of keys of the map.
+func (m *MultiMap[K, V]) KeyLen() int {
+	return m.sets.Len()
+}
+
+// Count returns the number of values of the key k.
+func (m *MultiMap[K, V]) Count(k K) int {
+	if s, ok := m.sets.Get(k); ok {
+		return s.Len()
+	}
+	return 0
+}
+
+// Contains reports whether the key k has the value v.
+func (m *MultiMap[K, V]) Contains(k K, v V) bool {
+	s, ok := m.sets.Get(k)
+	return ok && s.Contains(v)
+}
+
+// Get returns the values of the key k, which is empty if k doesn't exist. The set is a clone, so it can be changed
+// without changing the map; it should be destroyed once no longer used.
+func (m *MultiMap[K, V]) Get(k K) *PersistentSet[V] {
+	if s, ok := m.sets.Get(k); ok {
+		return s.Clone()
+	}
+	return NewPersistentSet[V](m.hasher)
+}
+
+// Add adds the value v to the key k and reports whether it was added, that is whether k didn't have it already.
+func (m *MultiMap[K, V]) Add(k K, v V) bool {
+	s, ok := m.sets.Get(k)
+	if ok && s.Contains(v) {
+		return false
+	}
+
+	if ok {
+		s = s.Clone()
+	} else {
+		s = NewPersistentSet[V](m.hasher)
+	}
+	s.Add(v)
+
+	m.sets.Put(k, s, destroySet[K, V])
+	m.len++
+	return true
+}
+
+// Remove removes the value v from the key k and reports whether it was found. The key is removed with its last value.
+func (m *MultiMap[K, V]) Remove(k K, v V) bool {
+	s, ok := m.sets.Get(k)
+	if !ok || !s.Contains(v) {
+		return false
+	}
+
+	if s.Len() == 1 {
+		m.sets.Delete(k)
+	} else {
+		s = s.Clone()
+		s.Remove(v)
+		m.sets.Put(k, s, destroySet[K, V])
+	}
+
+	m.len--
+	return true
+}
+
+// RemoveAll removes the key k with all its values and returns the number of values removed.
+func (m *MultiMap[K, V]) RemoveAll(k K) int {
+	s, ok := m.sets.Get(k)
+	if !ok {
+		return 0
+	}
+
+	n := s.Len()
+	m.sets.Delete(k)
+	m.len -= n
+	return n
+}
+
+// Range calls f for every key-value pair of the map, in no particular order, until f returns true.
+func (m *MultiMap[K, V]) Range(f func(k K, v V) bool) {
+	m.sets.Range(func(k K, s *PersistentSet[V]) bool {
+		stop := false
+		s.Range(func(v V) bool {
+			stop = f(k, v)
+			return stop
+		})
+		return stop
+	})
+}
+
+// RangeKeys calls f for every key of the map with its number of values, in no particular order, until f returns true.
+func (m *MultiMap[K, V]) RangeKeys(f func(k K, count int) bool) {
+	m.sets.Range(func(k K, s *PersistentSet[V]) bool {
+		return f(k, s.Len())
+	})
+}
+
+// Clone returns a copy of the map in O(1). The two maps share their keys and sets until they are changed.
+func (m *MultiMap[K, V]) Clone() *MultiMap[K, V] {
+	return &MultiMap[K, V]{
+		sets:   m.sets.Clone(),
+		hasher: m.hasher,
+		len:    m.len,
+	}
+}
+
+// Destroy releases the map. The sets it holds are destroyed once no other version of the map holds them.
+func (m *MultiMap[K, V]) Destroy() {
+	m.sets.Destroy()
+	m.len = 0
+}
+
+// destroySet is the release callback of the records of a MultiMap.
+func destroySet[K, V comparable](k K, s *PersistentSet[V]) {
+	s.Destroy()
+}
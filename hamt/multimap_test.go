@@ -0,0 +1,136 @@
+package hamt
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func validateMultiMap(t *testing.T, m *MultiMap[int, int], expected map[int]map[int]bool) {
+	t.Helper()
+
+	pairs := 0
+	for k, vs := range expected {
+		pairs += len(vs)
+		if m.Count(k) != len(vs) {
+			t.Fatalf("Count(%d) = %d, want %d", k, m.Count(k), len(vs))
+		}
+		for v := range vs {
+			if !m.Contains(k, v) {
+				t.Fatalf("Contains(%d, %d) = false", k, v)
+			}
+		}
+	}
+
+	if m.Len() != pairs || m.KeyLen() != len(expected) {
+		t.Fatalf("Len() = %d, KeyLen() = %d, want %d, %d", m.Len(), m.KeyLen(), pairs, len(expected))
+	}
+
+	visited := 0
+	m.Range(func(k, v int) bool {
+		if !expected[k][v] {
+			t.Fatalf("Range() visited %d: %d", k, v)
+		}
+		visited++
+		return false
+	})
+	if visited != pairs {
+		t.Fatalf("Range() visited %d pairs, want %d", visited, pairs)
+	}
+}
+
+func TestMultiMap(t *testing.T) {
+	t.Run("Random", func(t *testing.T) {
+		m := NewMultiMap[int, int](newIntHasher(), newIntHasher())
+		expected := make(map[int]map[int]bool)
+
+		type version struct {
+			m        *MultiMap[int, int]
+			expected map[int]map[int]bool
+		}
+		var versions []version
+
+		for i := 0; i < 10000; i++ {
+			k, v := rand.Intn(50), rand.Intn(20)
+			switch rand.Intn(5) {
+			case 0, 1:
+				if got, want := m.Add(k, v), !expected[k][v]; got != want {
+					t.Fatalf("Add(%d, %d) = %v, want %v", k, v, got, want)
+				}
+				if expected[k] == nil {
+					expected[k] = make(map[int]bool)
+				}
+				expected[k][v] = true
+			case 2, 3:
+				if got, want := m.Remove(k, v), expected[k][v]; got != want {
+					t.Fatalf("Remove(%d, %d) = %v, want %v", k, v, got, want)
+				}
+				delete(expected[k], v)
+				if len(expected[k]) == 0 {
+					delete(expected, k)
+				}
+			default:
+				if got, want := m.RemoveAll(k), len(expected[k]); got != want {
+					t.Fatalf("RemoveAll(%d) = %d, want %d", k, got, want)
+				}
+				delete(expected, k)
+			}
+
+			if i%1000 == 0 {
+				validateMultiMap(t, m, expected)
+
+				snapshot := make(map[int]map[int]bool, len(expected))
+				for k, vs := range expected {
+					snapshot[k] = make(map[int]bool, len(vs))
+					for v := range vs {
+						snapshot[k][v] = true
+					}
+				}
+				versions = append(versions, version{m.Clone(), snapshot})
+			}
+		}
+
+		validateMultiMap(t, m, expected)
+		for _, v := range versions {
+			validateMultiMap(t, v.m, v.expected)
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		m := NewMultiMap[string, int](newHasher[string](), newIntHasher())
+		for _, v := range []int{3, 1, 2} {
+			m.Add("tag", v)
+		}
+
+		s := m.Get("tag")
+		values := s.Keys()
+		sort.Ints(values)
+		if !slices.Equal(values, []int{1, 2, 3}) {
+			t.Errorf("Get() = %v, want [1 2 3]", values)
+		}
+
+		s.Add(4)
+		s.Destroy()
+		if m.Count("tag") != 3 || m.Contains("tag", 4) {
+			t.Errorf("changing the set returned by Get() should not change the map")
+		}
+
+		if empty := m.Get("missing"); empty.Len() != 0 {
+			t.Errorf("Get() of a missing key should be empty")
+		}
+
+		var keys []string
+		m.RangeKeys(func(k string, count int) bool {
+			if count != 3 {
+				t.Errorf("RangeKeys() visited %s with %d values, want 3", k, count)
+			}
+			keys = append(keys, k)
+			return false
+		})
+		if !slices.Equal(keys, []string{"tag"}) {
+			t.Errorf("RangeKeys() visited %v", keys)
+		}
+	})
+}
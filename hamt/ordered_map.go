@@ -0,0 +1,174 @@
+package hamt
+
+import (
+	"encoding/json"
+
+	"github.com/nnhatnam/immutable/RRBTree"
+)
+
+// minCompactSize is the number of stale slots an OrderedMap accepts before compacting, whatever its length.
+const minCompactSize = 32
+
+// OrderedMap is a persistent map iterating in insertion order.
+// The entries live in a PersistentHAMT mapping each key to its value and its sequence number, the position of the key
+// in order, an RRBTree holding the keys in insertion order. Deleting or moving a key leaves a stale slot in order,
+// recognized by its sequence number not matching the one of the entry. When stale slots outnumber the entries, order is
+// rebuilt without them and the sequence numbers are renumbered.
+type OrderedMap[K comparable, V any] struct {
+	entries *PersistentHAMT[K, orderedEntry[V]]
+	order   RRBTree.RRBTree[K]
+}
+
+type orderedEntry[V any] struct {
+	value V
+	seq   int
+}
+
+// NewOrderedMap returns an empty OrderedMap using h to hash the keys.
+func NewOrderedMap[K comparable, V any](h Hasher[K]) *OrderedMap[K, V] {
+	return &OrderedMap[K, V]{
+		entries: NewPersistentHAMT[K, orderedEntry[V]](h),
+		order:   RRBTree.NewRRBTree[K](),
+	}
+}
+
+func (m *OrderedMap[K, V]) Len() int {
+	return m.entries.Len()
+}
+
+// Get returns the value of the key k and whether it was found.
+func (m *OrderedMap[K, V]) Get(k K) (V, bool) {
+	e, ok := m.entries.Get(k)
+	return e.value, ok
+}
+
+// Set sets the value of the key k to v. A new key is added at the end of the order; an existing key keeps its place.
+func (m *OrderedMap[K, V]) Set(k K, v V) {
+	m.entries.Upsert(k, func(old orderedEntry[V], ok bool) orderedEntry[V] {
+		if ok {
+			return orderedEntry[V]{value: v, seq: old.seq}
+		}
+		return orderedEntry[V]{value: v, seq: m.push(k)}
+	})
+}
+
+// push appends k to the order and returns its sequence number.
+func (m *OrderedMap[K, V]) push(k K) int {
+	m.order = m.order.Append(k)
+	return m.order.Len() - 1
+}
+
+// Delete removes the key k and reports whether it was found.
+func (m *OrderedMap[K, V]) Delete(k K) bool {
+	if !m.entries.Delete(k) {
+		return false
+	}
+
+	m.maybeCompact()
+	return true
+}
+
+// MoveToEnd moves the key k to the end of the order and reports whether it was found.
+func (m *OrderedMap[K, V]) MoveToEnd(k K) bool {
+	_, found := m.entries.Update(k, func(old orderedEntry[V], ok bool) (orderedEntry[V], bool) {
+		if !ok {
+			return old, false
+		}
+		if old.seq == m.order.Len()-1 { // already at the end
+			return old, true
+		}
+		return orderedEntry[V]{value: old.value, seq: m.push(k)}, true
+	})
+
+	if found {
+		m.maybeCompact()
+	}
+	return found
+}
+
+// maybeCompact rebuilds the order when it holds more stale slots than entries.
+func (m *OrderedMap[K, V]) maybeCompact() {
+	stale := m.order.Len() - m.entries.Len()
+	if stale <= minCompactSize || stale <= m.entries.Len() {
+		return
+	}
+
+	order := RRBTree.NewRRBTree[K]()
+	m.Range(func(k K, v V) bool {
+		m.entries.Set(k, orderedEntry[V]{value: v, seq: order.Len()})
+		order = order.Append(k)
+		return false
+	})
+	m.order = order
+}
+
+// Range calls f for every key-value pair in insertion order, until f returns true.
+func (m *OrderedMap[K, V]) Range(f func(k K, v V) bool) {
+	for seq, n := 0, m.order.Len(); seq < n; seq++ {
+		k := m.order.Get(seq)
+		if e, ok := m.entries.Get(k); ok && e.seq == seq && f(k, e.value) {
+			return
+		}
+	}
+}
+
+// Keys returns the keys of the map in insertion order.
+func (m *OrderedMap[K, V]) Keys() []K {
+	keys := make([]K, 0, m.Len())
+	m.Range(func(k K, v V) bool {
+		keys = append(keys, k)
+		return false
+	})
+	return keys
+}
+
+// Clone returns a copy of the map in O(1). The two maps share their nodes until they are changed.
+func (m *OrderedMap[K, V]) Clone() *OrderedMap[K, V] {
+	return &OrderedMap[K, V]{
+		entries: m.entries.Clone(),
+		order:   m.order,
+	}
+}
+
+func (m *OrderedMap[K, V]) Destroy() {
+	m.entries.Destroy()
+	m.order = RRBTree.NewRRBTree[K]()
+}
+
+// MarshalJSON encodes the map in insertion order, as a JSON object if the keys are strings, or as an array of
+// [key, value] pairs otherwise.
+func (m *OrderedMap[K, V]) MarshalJSON() ([]byte, error) {
+	if !isStringKind[K]() {
+		pairs := make([][2]any, 0, m.Len())
+		m.Range(func(k K, v V) bool {
+			pairs = append(pairs, [2]any{k, v})
+			return false
+		})
+		return json.Marshal(pairs)
+	}
+
+	buf := []byte{'{'}
+	var err error
+	m.Range(func(k K, v V) bool {
+		if len(buf) > 1 {
+			buf = append(buf, ',')
+		}
+
+		var data []byte
+		if data, err = json.Marshal(k); err != nil {
+			return true
+		}
+		buf = append(append(buf, data...), ':')
+
+		if data, err = json.Marshal(v); err != nil {
+			return true
+		}
+		buf = append(buf, data...)
+		return false
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, '}'), nil
+}
@@ -0,0 +1,166 @@
+package hamt
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+// orderedModel is the reference implementation of OrderedMap used by the tests.
+type orderedModel struct {
+	keys   []int
+	values map[int]int
+}
+
+func (om *orderedModel) set(k, v int) {
+	if _, ok := om.values[k]; !ok {
+		om.keys = append(om.keys, k)
+	}
+	om.values[k] = v
+}
+
+func (om *orderedModel) remove(k int) {
+	if i := slices.Index(om.keys, k); i >= 0 {
+		om.keys = slices.Delete(om.keys, i, i+1)
+		delete(om.values, k)
+	}
+}
+
+func (om *orderedModel) moveToEnd(k int) {
+	if i := slices.Index(om.keys, k); i >= 0 {
+		om.keys = append(slices.Delete(om.keys, i, i+1), k)
+	}
+}
+
+func validateOrderedMap(t *testing.T, m *OrderedMap[int, int], om *orderedModel) {
+	t.Helper()
+
+	if m.Len() != len(om.keys) {
+		t.Fatalf("Len() = %d, want %d", m.Len(), len(om.keys))
+	}
+
+	var keys []int
+	m.Range(func(k, v int) bool {
+		if v != om.values[k] {
+			t.Fatalf("Range() visited %d: %d, want %d", k, v, om.values[k])
+		}
+		keys = append(keys, k)
+		return false
+	})
+	if !slices.Equal(keys, om.keys) {
+		t.Fatalf("Range() visited %v, want %v", keys, om.keys)
+	}
+
+	if stale := m.order.Len() - m.Len(); stale > minCompactSize && stale > m.Len() {
+		t.Fatalf("the order holds %d stale slots for %d entries", stale, m.Len())
+	}
+}
+
+func TestOrderedMap(t *testing.T) {
+	t.Run("Random", func(t *testing.T) {
+		m := NewOrderedMap[int, int](newIntHasher())
+		om := &orderedModel{values: make(map[int]int)}
+
+		for i := 0; i < 5000; i++ {
+			k := rand.Intn(300)
+			switch rand.Intn(5) {
+			case 0, 1:
+				m.Set(k, i)
+				om.set(k, i)
+			case 2:
+				_, want := om.values[k]
+				if got := m.Delete(k); got != want {
+					t.Fatalf("Delete(%d) = %v, want %v", k, got, want)
+				}
+				om.remove(k)
+			case 3:
+				_, want := om.values[k]
+				if got := m.MoveToEnd(k); got != want {
+					t.Fatalf("MoveToEnd(%d) = %v, want %v", k, got, want)
+				}
+				om.moveToEnd(k)
+			default:
+				want, wantOk := om.values[k]
+				if got, ok := m.Get(k); got != want || ok != wantOk {
+					t.Fatalf("Get(%d) = %d, %v, want %d, %v", k, got, ok, want, wantOk)
+				}
+			}
+
+			if i%100 == 0 {
+				validateOrderedMap(t, m, om)
+			}
+		}
+		validateOrderedMap(t, m, om)
+	})
+
+	t.Run("Clone", func(t *testing.T) {
+		m := NewOrderedMap[int, int](newIntHasher())
+		for i := 0; i < 100; i++ {
+			m.Set(i, i)
+		}
+
+		clone := m.Clone()
+		for i := 0; i < 100; i += 2 {
+			clone.Delete(i)
+			clone.MoveToEnd(i + 1)
+		}
+		clone.Set(1000, 1000)
+
+		om := &orderedModel{values: make(map[int]int)}
+		for i := 0; i < 100; i++ {
+			om.set(i, i)
+		}
+		validateOrderedMap(t, m, om)
+
+		if keys := clone.Keys(); len(keys) != 51 || keys[0] != 1 || keys[1] != 3 || keys[50] != 1000 {
+			t.Errorf("clone.Keys() = %v", keys)
+		}
+	})
+
+	t.Run("RangeStop", func(t *testing.T) {
+		m := NewOrderedMap[int, int](newIntHasher())
+		for i := 0; i < 10; i++ {
+			m.Set(i, i)
+		}
+
+		var keys []int
+		m.Range(func(k, v int) bool {
+			keys = append(keys, k)
+			return k == 3
+		})
+		if !slices.Equal(keys, []int{0, 1, 2, 3}) {
+			t.Errorf("Range() should stop when f returns true, visited %v", keys)
+		}
+	})
+}
+
+func TestOrderedMapJSON(t *testing.T) {
+	m := NewOrderedMap[string, int](newHasher[string]())
+	for i, k := range []string{"zebra", "apple", "mango", "kiwi"} {
+		m.Set(k, i)
+	}
+	m.MoveToEnd("apple")
+	m.Delete("mango")
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if want := `{"zebra":0,"kiwi":3,"apple":1}`; string(data) != want {
+		t.Errorf("json.Marshal() = %s, want %s", data, want)
+	}
+
+	pairs := NewOrderedMap[int, string](newIntHasher())
+	pairs.Set(2, "b")
+	pairs.Set(1, "a")
+	if data, _ := json.Marshal(pairs); string(data) != `[[2,"b"],[1,"a"]]` {
+		t.Errorf("json.Marshal() = %s", data)
+	}
+
+	empty := NewOrderedMap[string, int](newHasher[string]())
+	if data, _ := json.Marshal(empty); string(data) != `{}` {
+		t.Errorf("json.Marshal() = %s, want {}", data)
+	}
+}
@@ -0,0 +1,152 @@
+package hamt
+
+import (
+	"math/bits"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ParallelRange calls f for every entry of the map, in no particular order, from up to workers goroutines, or
+// GOMAXPROCS goroutines if workers is not positive. The slots of the root node are independent sub-tries, which are
+// handed out to the goroutines one at a time.
+// When f returns an error, the goroutines stop at their next entry and ParallelRange returns the first error. If f
+// panics, the other goroutines stop the same way and the panic is raised again in the caller.
+// The map must not be changed until ParallelRange returns; clones can be changed.
+func (m *PersistentHAMT[K, V]) ParallelRange(workers int, f func(k K, v V) error) error {
+	return m.parallel(workers, func(worker int, k K, v V) error {
+		return f(k, v)
+	})
+}
+
+// ParallelFold folds the entries of m like Fold, from up to workers goroutines, or GOMAXPROCS goroutines if workers is
+// not positive. Every goroutine folds its part of the map starting from init(), then the partial results are combined
+// with merge, which must be associative and commutative since the entries are split in no particular order.
+// Panics are raised again in the caller, like in ParallelRange.
+func ParallelFold[K comparable, V any, A any](m *PersistentHAMT[K, V], workers int, init func() A, f func(acc A, k K, v V) A, merge func(a, b A) A) A {
+	workers = m.workers(workers)
+
+	accs := make([]A, workers)
+	for i := range accs {
+		accs[i] = init()
+	}
+
+	_ = m.parallel(workers, func(worker int, k K, v V) error {
+		accs[worker] = f(accs[worker], k, v)
+		return nil
+	})
+
+	acc := accs[0]
+	for _, a := range accs[1:] {
+		acc = merge(acc, a)
+	}
+	return acc
+}
+
+// workers returns the number of goroutines to use for a parallel operation: at most one per slot of the root node.
+func (m *PersistentHAMT[K, V]) workers(workers int) int {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	m.thaw()
+
+	if m.root != nil {
+		if slots := bits.OnesCount64(m.root.dataMap | m.root.nodeMap); workers > slots {
+			workers = slots
+		}
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// parallel calls visit for every entry of the map from several goroutines, giving each call the index of its
+// goroutine, in [0, m.workers(workers)).
+func (m *PersistentHAMT[K, V]) parallel(workers int, visit func(worker int, k K, v V) error) error {
+	m.thaw()
+	root := m.root
+	if root == nil || root.isEmpty() {
+		return nil
+	}
+
+	var (
+		slots = root.dataMap | root.nodeMap
+		next  int32 // the index of the next slot to hand out, in the order of slots
+		stop  int32
+
+		mu       sync.Mutex
+		firstErr error
+		panicVal any
+		panicked bool
+
+		wg sync.WaitGroup
+	)
+
+	fail := func(err error, p any, isPanic bool) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case isPanic && !panicked:
+			panicVal, panicked = p, true
+		case !isPanic && firstErr == nil:
+			firstErr = err
+		}
+		atomic.StoreInt32(&stop, 1)
+	}
+
+	work := func(worker int) {
+		defer wg.Done()
+		defer func() {
+			if p := recover(); p != nil {
+				fail(nil, p, true)
+			}
+		}()
+
+		iter := func(k K, v V) bool {
+			if atomic.LoadInt32(&stop) != 0 {
+				return true
+			}
+			if err := visit(worker, k, v); err != nil {
+				fail(err, nil, false)
+				return true
+			}
+			return false
+		}
+
+		for {
+			i := int(atomic.AddInt32(&next, 1) - 1)
+			if i >= bits.OnesCount64(slots) || atomic.LoadInt32(&stop) != 0 {
+				return
+			}
+
+			// the i-th used slot of the root
+			s := slots
+			for j := 0; j < i; j++ {
+				s &= s - 1
+			}
+			r, child := root.TryGetBlock(bits.TrailingZeros64(s))
+
+			if r != nil {
+				iter(r.key, r.value)
+			} else {
+				m._range(child, iter)
+			}
+		}
+	}
+
+	workers = m.workers(workers)
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go work(w)
+	}
+	wg.Wait()
+
+	if panicked {
+		panic(panicVal)
+	}
+	return firstErr
+}
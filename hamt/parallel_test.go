@@ -0,0 +1,135 @@
+package hamt
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallelRange(t *testing.T) {
+	const size = 100000
+
+	src := make(map[int]int, size)
+	for i := 0; i < size; i++ {
+		src[i] = i
+	}
+	m := FromMap[int, int](newIntHasher(), src)
+
+	t.Run("Visit", func(t *testing.T) {
+		for _, workers := range []int{0, 1, 3, 64, 1000} {
+			var seen [size]int32
+			err := m.ParallelRange(workers, func(k, v int) error {
+				if k != v {
+					t.Errorf("visited %d: %d", k, v)
+				}
+				atomic.AddInt32(&seen[k], 1)
+				return nil
+			})
+
+			if err != nil {
+				t.Fatalf("ParallelRange(%d) error = %v", workers, err)
+			}
+			for k := range seen {
+				if seen[k] != 1 {
+					t.Fatalf("ParallelRange(%d) visited %d %d times", workers, k, seen[k])
+				}
+			}
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		errStop := errors.New("stop")
+		var visited int32
+
+		err := m.ParallelRange(4, func(k, v int) error {
+			if atomic.AddInt32(&visited, 1) == 1000 {
+				return errStop
+			}
+			return nil
+		})
+
+		if err != errStop {
+			t.Errorf("ParallelRange() error = %v, want %v", err, errStop)
+		}
+		if n := atomic.LoadInt32(&visited); n >= size {
+			t.Errorf("ParallelRange() visited %d entries after an error", n)
+		}
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		var visited int32
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("recover() = %v, want boom", p)
+			}
+			if n := atomic.LoadInt32(&visited); n >= size {
+				t.Errorf("ParallelRange() visited %d entries after a panic", n)
+			}
+		}()
+
+		_ = m.ParallelRange(4, func(k, v int) error {
+			if atomic.AddInt32(&visited, 1) == 1000 {
+				panic("boom")
+			}
+			return nil
+		})
+		t.Errorf("ParallelRange() should panic")
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		for _, empty := range []*PersistentHAMT[int, int]{NewPersistentHAMT[int, int](newIntHasher()), {}} {
+			if err := empty.ParallelRange(4, func(k, v int) error { return errors.New("called") }); err != nil {
+				t.Errorf("ParallelRange() on an empty map error = %v", err)
+			}
+		}
+	})
+}
+
+func TestParallelFold(t *testing.T) {
+	m := NewPersistentHAMT[int, int](newIntHasher())
+	want := 0
+	for i := 0; i < 50000; i++ {
+		m.Set(i, i*3)
+		want += i * 3
+	}
+
+	sum := func(acc, k, v int) int { return acc + v }
+	add := func(a, b int) int { return a + b }
+	zero := func() int { return 0 }
+
+	for _, workers := range []int{0, 1, 8} {
+		if got := ParallelFold(m, workers, zero, sum, add); got != want {
+			t.Errorf("ParallelFold(%d) = %d, want %d", workers, got, want)
+		}
+	}
+
+	if got := Fold(m, 0, sum); got != want {
+		t.Errorf("Fold() = %d, want %d", got, want)
+	}
+
+	if got := ParallelFold(NewPersistentHAMT[int, int](newIntHasher()), 4, func() int { return 7 }, sum, add); got != 7 {
+		t.Errorf("ParallelFold() on an empty map = %d, want 7", got)
+	}
+}
+
+func BenchmarkParallelRange(b *testing.B) {
+	m, _ := benchmarkMap(1000000)
+	work := func(k, v int) int {
+		for i := 0; i < 100; i++ {
+			v = v*31 + k
+		}
+		return v
+	}
+
+	b.Run("Range", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Fold(m, 0, func(acc, k, v int) int { return acc + work(k, v) })
+		}
+	})
+
+	b.Run("ParallelFold", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ParallelFold(m, 0, func() int { return 0 }, func(acc, k, v int) int { return acc + work(k, v) }, func(a, b int) int { return a + b })
+		}
+	})
+}
@@ -3,7 +3,6 @@ package hamt
 import (
 	"math/bits"
 	"sync/atomic"
-	"unsafe"
 
 	"github.com/nnhatnam/immutable/slice"
 )
@@ -11,7 +10,6 @@ import (
 const (
 	childPerNode   = 64
 	arity          = 6
-	width          = 2
 	exhaustedLevel = childPerNode / arity
 
 	maxDepth = 55 // 64-bit hash, 6-bit per level, 64/6 ~ 11, 11*5 = 55 (accepted 5 rehashes)
@@ -24,241 +22,210 @@ type Hasher[K comparable] interface {
 	Rehash(key K, prevHashCount int) uint64
 }
 
-type mapNode[K comparable, V any] struct {
-	bitmap   uint64
-	refCount int32
-
-	contentArray []unsafe.Pointer // either suffix hash or []entry[K, V], *Value is stored in even slots, []entry[K, V] in odd slots.
+// entry is the constraint of what a trieNode stores in its data slots: a *record for a PersistentHAMT, a bare key
+// for a PersistentSet. incRef and decRef follow the reference counting of the nodes; incRef returns the entry itself.
+type entry[K comparable, E any] interface {
+	comparable
+	entryKey() K
+	incRef() E
+	decRef()
 }
 
-//func newMapNode[K comparable, V any]() *mapNode[K, V] {
-//	return &mapNode[K, V]{
-//		contentArray: make([]unsafe.Pointer, 0),
-//	}
-//}
+// trieNode is a node of the trie in the CHAMP layout.
+// dataMap marks the slots holding an entry, nodeMap marks the slots holding a sub-node. The two bitmaps never overlap.
+// entries and sub-nodes are packed in slot order in their own typed array, so the position of an entry is the number of
+// bits set below its slot in the corresponding bitmap. Empty slots take no space.
+// The previous layout kept a (record, sub-node) pair per used slot in a single []unsafe.Pointer, one of the two always
+// nil. The split arrays take the same memory per node despite the size and debug fields, range and clone faster, and
+// need no unsafe; BenchmarkNodeLayout compares both layouts.
+type trieNode[K comparable, E entry[K, E]] struct {
+	dataMap  uint64
+	nodeMap  uint64
+	refCount int32
+	size     int  // the number of entries in the sub-trie
+	debug    bool // created in debug mode
 
-func newMapNodeWithRef[K comparable, V any]() *mapNode[K, V] {
-	return &mapNode[K, V]{
-		contentArray: make([]unsafe.Pointer, 0),
-		refCount:     1,
-	}
+	entries []E
+	nodes   []*trieNode[K, E]
 }
 
-func (n *mapNode[K, V]) shallowCloneWithRef() *mapNode[K, V] {
-	//atomic.AddInt32(&n.refCount, -1)
-	n.decRef()
-
-	n1 := &mapNode[K, V]{
-		bitmap:   n.bitmap,
+func newTrieNode[K comparable, E entry[K, E]]() *trieNode[K, E] {
+	n := &trieNode[K, E]{
 		refCount: 1,
 	}
 
-	n1.contentArray = make([]unsafe.Pointer, len(n.contentArray))
-	for i := 0; i < len(n.contentArray)/2; i++ {
+	if debugOn() {
+		n.debug = true
+		atomic.AddInt64(&liveNodes, 1)
+	}
+	return n
+}
 
-		recordIdx := i * 2
-		nodeIdx := i*2 + 1
+func newMapNodeWithRef[K comparable, V any]() *trieNode[K, *record[K, V]] {
+	return newTrieNode[K, *record[K, V]]()
+}
 
-		n1.contentArray[recordIdx] = n.contentArray[recordIdx]
-		(*record[K, V])(n1.contentArray[recordIdx]).incRef()
+// shallowCloneWithRef returns a copy of the node and releases the reference of the caller to the node.
+func (n *trieNode[K, E]) shallowCloneWithRef() *trieNode[K, E] {
+	n1 := n.clone()
+	n.decRef()
+	return n1
+}
 
-		//if n.contentArray[recordIdx] != nil {
-		//	n1.contentArray[recordIdx] = n.contentArray[recordIdx]
-		//	(*record[K, V])(n1.contentArray[recordIdx]).incRef()
-		//}
+// clone returns a copy of the node with its own reference to every entry and sub-node. The node itself is left
+// untouched.
+func (n *trieNode[K, E]) clone() *trieNode[K, E] {
+	n1 := newTrieNode[K, E]()
+	n1.dataMap, n1.nodeMap, n1.size = n.dataMap, n.nodeMap, n.size
 
-		n1.contentArray[nodeIdx] = n.contentArray[nodeIdx]
-		(*mapNode[K, V])(n1.contentArray[nodeIdx]).incRef()
-		//if n.contentArray[nodeIdx] != nil {
-		//	n1.contentArray[nodeIdx] = n.contentArray[nodeIdx]
-		//	(*mapNode[K, V])(n1.contentArray[nodeIdx]).incRef()
-		//}
+	n1.entries = make([]E, len(n.entries))
+	for i, e := range n.entries {
+		n1.entries[i] = e.incRef()
+	}
 
+	n1.nodes = make([]*trieNode[K, E], len(n.nodes))
+	for i, child := range n.nodes {
+		n1.nodes[i] = child.incRef()
 	}
-	//copy(n1.contentArray, n.contentArray)
 
 	return n1
 
 }
 
-//func (n *mapNode[K, V]) shallowClone() *mapNode[K, V] {
-//	n1 := &mapNode[K, V]{
-//		bitmap:   n.bitmap,
-//		refCount: 1,
-//	}
-//
-//	n1.contentArray = make([]unsafe.Pointer, len(n.contentArray))
-//	copy(n1.contentArray, n.contentArray)
-//
-//	return n1
-//}
-
-func (n *mapNode[K, V]) incRef() *mapNode[K, V] {
+func (n *trieNode[K, E]) incRef() *trieNode[K, E] {
 	if n != nil {
 		atomic.AddInt32(&n.refCount, 1)
 	}
 	return n
 }
 
-func (n *mapNode[K, V]) decRef() {
+func (n *trieNode[K, E]) decRef() {
 	if n == nil {
 		return
 	}
 
-	if atomic.AddInt32(&n.refCount, -1) == 0 {
-		// free the node
-		for i := 0; i < len(n.contentArray)/2; i++ {
-			recordIdx := i * 2
-			nodeIdx := i*2 + 1
-
-			if n.contentArray[recordIdx] != nil {
-				// free the record
+	refCount := atomic.AddInt32(&n.refCount, -1)
+	if n.debug {
+		debugRelease(refCount, &liveNodes, "node")
+	}
 
-				(*record[K, V])(n.contentArray[recordIdx]).decRef()
-				n.contentArray[recordIdx] = nil
-			}
+	if refCount == 0 {
+		// free the node
+		var zero E
+		for i, e := range n.entries {
+			e.decRef()
+			n.entries[i] = zero
+		}
 
-			if n.contentArray[nodeIdx] != nil {
-				// free the node
-				n1 := (*mapNode[K, V])(n.contentArray[nodeIdx])
-				n1.decRef()
-				n.contentArray[nodeIdx] = nil
-			}
+		for i, child := range n.nodes {
+			child.decRef()
+			n.nodes[i] = nil
 		}
-		n.contentArray = nil
+
+		n.entries = nil
+		n.nodes = nil
 	}
 }
 
-func (n *mapNode[K, V]) contentBlockInfo(loc int) (mask uint64, blockIndex int) {
-	mask = 1 << loc
+// isEmpty reports whether the node holds neither an entry nor a sub-node.
+func (n *trieNode[K, E]) isEmpty() bool {
+	return n.dataMap|n.nodeMap == 0
+}
 
-	if mask > 0 {
-		// find the block index
-		blockIndex = bits.OnesCount64(n.bitmap & (mask - 1))
+// singleEntry returns the only entry of the node if the node holds exactly one entry and no sub-node; ok is false
+// otherwise. A sub-node in this shape is redundant and can be replaced by its entry in the parent slot.
+func (n *trieNode[K, E]) singleEntry() (e E, ok bool) {
+	if n == nil || n.nodeMap != 0 || len(n.entries) != 1 {
+		return
 	}
 
-	return
+	return n.entries[0], true
 }
 
-//func (n *mapNode[K, V]) getContentIdx(loc int) (recordIdx int, nodeIdx int) {
-//	var mask uint64 = 1 << loc
-//	blockIdx := 0
-//
-//	if mask > 0 {
-//		// find the block index
-//		blockIdx = bits.OnesCount64(n.bitmap & (mask - 1))
-//	}
-//
-//	recordIdx = width * blockIdx
-//	nodeIdx = recordIdx + 1
-//	return recordIdx, nodeIdx
-//}
+// entryIndex returns the index in entries of the entry stored in the slot of the given mask.
+func (n *trieNode[K, E]) entryIndex(mask uint64) int {
+	return bits.OnesCount64(n.dataMap & (mask - 1))
+}
 
-func (n *mapNode[K, V]) getContentIndexFromMask(mask uint64) (recordIdx int, nodeIdx int) {
+// nodeIndex returns the index in nodes of the sub-node stored in the slot of the given mask.
+func (n *trieNode[K, E]) nodeIndex(mask uint64) int {
+	return bits.OnesCount64(n.nodeMap & (mask - 1))
+}
 
-	blockIdx := 0
+// TryGetBlock returns the entry or the sub-node stored at the given location. The entry is the zero E unless the
+// location holds one, and the sub-node is nil unless the location holds one.
+func (n *trieNode[K, E]) TryGetBlock(loc int) (e E, child *trieNode[K, E]) {
 
-	if mask > 0 {
-		// find the block index
-		blockIdx = bits.OnesCount64(n.bitmap & (mask - 1))
-	}
+	var mask uint64 = 1 << loc
 
-	recordIdx = width * blockIdx
-	nodeIdx = recordIdx + 1
-	return recordIdx, nodeIdx
-}
-
-// TryInsertBlock tries to set the record at the given location. If the location is already occupied, return false
-//func (n *mapNode[K, V]) TryInsertBlock(loc int, r *record[K, V], n1 *mapNode[K, V]) bool {
-//
-//	mask, blockIdx := n.contentBlockInfo(loc)
-//
-//	// if the block is not empty, we can't set. return false
-//	if n.bitmap&mask != 0 {
-//		return false
-//	}
-//
-//	recordIdx := width * blockIdx
-//
-//	n.contentArray = slices.Insert(n.contentArray, recordIdx, unsafe.Pointer(r), unsafe.Pointer(n1))
-//	n.bitmap |= mask
-//	return true
-//
-//}
-
-//func (n *mapNode[K, V]) UpdateBlock(loc int, r *record[K, V], n1 *mapNode[K, V]) bool {
-//
-//	_, blockIdx := n.contentBlockInfo(loc)
-//	recordIdx := width * blockIdx
-//
-//	_ = n.contentArray[recordIdx] // bounds check hint to compiler; see golang.org/issue/14808
-//
-//	//n.bitmap |= mask
-//	n.contentArray[recordIdx] = unsafe.Pointer(r)
-//	n.contentArray[recordIdx+1] = unsafe.Pointer(n1)
-//
-//	return true
-//
-//}
-
-func (n *mapNode[K, V]) TryGetBlock(loc int) (*record[K, V], *mapNode[K, V]) {
-
-	_, blockIdx := n.contentBlockInfo(loc)
-	recordIdx := width * blockIdx
-	nodeIdx := recordIdx + 1
-	if recordIdx >= len(n.contentArray) {
-		return nil, nil
+	switch {
+	case n.dataMap&mask != 0:
+		return n.entries[n.entryIndex(mask)], nil
+	case n.nodeMap&mask != 0:
+		return e, n.nodes[n.nodeIndex(mask)]
 	}
 
-	//_ = n.contentArray[recordIdx:nodeIdx] // bounds check hint to compiler; see golang.org/issue/14808
-
-	return (*record[K, V])(n.contentArray[recordIdx]), (*mapNode[K, V])(n.contentArray[nodeIdx])
+	return
 }
 
-//func (n *mapNode[K, V]) updateRecord(recordIdx int, r *record[K, V]) {
-//
-//	_ = n.contentArray[recordIdx] // bounds check hint to compiler; see golang.org/issue/14808
-//	(*record[K, V])(n.contentArray[recordIdx]).decRef()
-//	n.contentArray[recordIdx] = unsafe.Pointer(r)
-//}
-
-type PersistentHAMT[K comparable, V any] struct {
-	root *mapNode[K, V]
+// trie holds the root, the number of entries and the hasher shared by PersistentHAMT and PersistentSet, with the
+// operations that only depend on the keys of the entries.
+type trie[K comparable, E entry[K, E]] struct {
+	root *trieNode[K, E]
 	len  int
 
 	hasher Hasher[K]
+}
+
+type PersistentHAMT[K comparable, V any] struct {
+	trie[K, *record[K, V]]
+
+	// frozen is the read-only Ctrie snapshot the map is a view of, until thaw converts it to nodes, see Ctrie.Snapshot.
+	frozen *Ctrie[K, V]
 
 	mutable bool // if true, the HAMT is mutable, otherwise it is immutable.
 }
 
 func NewPersistentHAMT[K comparable, V any](h Hasher[K]) *PersistentHAMT[K, V] {
-	return &PersistentHAMT[K, V]{
-		hasher: h,
-		root:   newMapNodeWithRef[K, V](),
+	m := &PersistentHAMT[K, V]{}
+	m.hasher = h
+	m.root = newMapNodeWithRef[K, V]()
+	return m
+}
+
+func (t *trie[K, E]) hash(key K, depth int) uint64 {
+	return hashAt(t.hasher, key, depth)
+}
+
+// keyHash returns the hash of the key k for the given depth, from hk if it is not nil.
+func (t *trie[K, E]) keyHash(k K, hk *HashedKey[K], depth int) uint64 {
+	if hk != nil {
+		return hk.hashAt(depth)
 	}
+	return t.hash(k, depth)
 }
 
-func (m *PersistentHAMT[K, V]) hash(key K, depth int) uint64 {
+// hashAt returns the hash of the key used at the given depth of the trie.
+func hashAt[K comparable](h Hasher[K], key K, depth int) uint64 {
 
 	prevHashCount := depth / (exhaustedLevel + 1)
 	if prevHashCount == 0 {
-		return m.hasher.Hash(key)
+		return h.Hash(key)
 	} else if depth > maxDepth {
 		panic("hash depth too deep, which mean there are too many collisions in the chosen hash function. Please choose a better hash function.")
 	}
 
-	return m.hasher.Rehash(key, prevHashCount)
+	return h.Rehash(key, prevHashCount)
 }
 
 func (m *PersistentHAMT[K, V]) Len() int {
+	m.thaw()
 	return m.len
 }
 
-// subroutine of mInsert
-func (m *PersistentHAMT[K, V]) mInsertDoubleRecord(n *mapNode[K, V], keyHash uint64, r1 *record[K, V], colHash uint64, r2 *record[K, V], depth int) *mapNode[K, V] {
-	// The double record situation only happens when we have a collision at the previous level.
-	// When a collision happens, we have to create a new node and insert the two records into the new node.
-	// So we know that n is a new node for sure.
+// mergeEntries creates a new node holding the two entries e1 and e2, whose hashes collide on every level above depth.
+// If they collide again at depth, the node holds a single sub-node and the merge goes one level deeper.
+func (t *trie[K, E]) mergeEntries(keyHash uint64, e1 E, colHash uint64, e2 E, depth int) *trieNode[K, E] {
 
 	level := depth % (exhaustedLevel + 1)
 	shift := level * arity
@@ -266,43 +233,35 @@ func (m *PersistentHAMT[K, V]) mInsertDoubleRecord(n *mapNode[K, V], keyHash uin
 	bitpos := bucket(keyHash, shift)
 	colpos := bucket(colHash, shift)
 
-	var mask uint64 = 1 << bitpos
-	recordIdx, _ := n.getContentIndexFromMask(mask)
+	n := newTrieNode[K, E]()
+	n.size = 2
 
 	if bitpos == colpos { // collision again
-		// we have to create a new node
-
-		n1 := newMapNodeWithRef[K, V]()
-		n.contentArray = slice.Insert(n.contentArray, recordIdx, nil, unsafe.Pointer(n1))
-		n.bitmap |= mask
-
 		if level == exhaustedLevel {
-			keyHash = m.hash(r1.key, depth+1)
-			colHash = m.hash(r2.key, depth+1)
+			keyHash = t.hash(e1.entryKey(), depth+1)
+			colHash = t.hash(e2.entryKey(), depth+1)
 		}
 
-		m.mInsertDoubleRecord(n1, keyHash, r1, colHash, r2, depth+1)
+		n.nodeMap = 1 << bitpos
+		n.nodes = []*trieNode[K, E]{t.mergeEntries(keyHash, e1, colHash, e2, depth+1)}
 		return n
 	}
 
-	n.contentArray = slice.Insert(n.contentArray, recordIdx, unsafe.Pointer(r1), nil)
-	n.bitmap |= mask
-	mask = 1 << colpos
-
-	recordIdx, _ = n.getContentIndexFromMask(mask)
+	n.dataMap = 1<<bitpos | 1<<colpos
+	if bitpos < colpos {
+		n.entries = []E{e1, e2}
+	} else {
+		n.entries = []E{e2, e1}
+	}
 
-	n.contentArray = slice.Insert(n.contentArray, recordIdx, unsafe.Pointer(r2), nil)
-	n.bitmap |= mask
-	m.len++
 	return n
 }
 
-func (m *PersistentHAMT[K, V]) replaceOrInsert(n *mapNode[K, V], keyHash uint64, depth int, r *record[K, V], pathCopy bool) *mapNode[K, V] {
+func (t *trie[K, E]) replaceOrInsert(n *trieNode[K, E], keyHash uint64, depth int, e E, pathCopy bool) *trieNode[K, E] {
 
 	if atomic.LoadInt32(&n.refCount) > 1 || pathCopy {
 		pathCopy = true
 		n = n.shallowCloneWithRef()
-		//fmt.Println("must shallow clone")
 	}
 
 	level := depth % (exhaustedLevel + 1)
@@ -311,176 +270,256 @@ func (m *PersistentHAMT[K, V]) replaceOrInsert(n *mapNode[K, V], keyHash uint64,
 
 	var mask uint64 = 1 << loc
 
-	recordIdx, nodeIdx := n.getContentIndexFromMask(mask)
-
-	// if the block is empty, we can insert the record directly
-	if n.bitmap&mask == 0 {
-
-		n.contentArray = slice.Insert(n.contentArray, recordIdx, unsafe.Pointer(r), nil)
-		n.bitmap |= mask
-		m.len++
-		return n
-	}
+	switch {
+	case n.dataMap&mask != 0:
+		entryIdx := n.entryIndex(mask)
+		colEntry := n.entries[entryIdx]
 
-	// if the block is not empty, we have to check if there is a collision
-	colRecord, n1 := (*record[K, V])(n.contentArray[recordIdx]), (*mapNode[K, V])(n.contentArray[nodeIdx])
-
-	if colRecord == nil {
+		if colEntry.entryKey() == e.entryKey() { // update
+			colEntry.decRef()
+			n.entries[entryIdx] = e
+			return n
+		}
 
+		// collision, push both entries down to a new sub-node
+		colHash := t.hash(colEntry.entryKey(), depth)
 		if level == exhaustedLevel {
-			keyHash = m.hash(r.key, depth+1)
+			keyHash = t.hash(e.entryKey(), depth+1)
+			colHash = t.hash(colEntry.entryKey(), depth+1)
 		}
-		n1 = m.replaceOrInsert(n1, keyHash, depth+1, r, pathCopy)
+		n1 := t.mergeEntries(keyHash, e, colHash, colEntry, depth+1)
 
-		n.contentArray[nodeIdx] = unsafe.Pointer(n1)
-		return n
-	} else if n1 == nil {
-		// n1 == nil && colRecord != nil => update or expand
-		if colRecord.key == r.key { // update
+		n.entries = slice.RemoveAt(n.entries, entryIdx)
+		n.dataMap ^= mask
+		n.nodes = slice.Insert(n.nodes, n.nodeIndex(mask), n1)
+		n.nodeMap |= mask
+		n.size++
+		t.len++
 
-			//n.updateRecord(recordIdx, r)
-			(*record[K, V])(n.contentArray[recordIdx]).decRef()
-			n.contentArray[recordIdx] = unsafe.Pointer(r)
-			return n
-		}
-
-		n.contentArray[recordIdx] = nil
-		n2 := newMapNodeWithRef[K, V]()
+	case n.nodeMap&mask != 0:
+		nodeIdx := n.nodeIndex(mask)
 
-		colHash := m.hash(colRecord.key, depth)
 		if level == exhaustedLevel {
-			keyHash = m.hash(r.key, depth+1)
-			colHash = m.hash(colRecord.key, depth+1)
+			keyHash = t.hash(e.entryKey(), depth+1)
 		}
-		n.contentArray[recordIdx] = nil // remove the record
-		n2 = m.mInsertDoubleRecord(n2, keyHash, r, colHash, colRecord, depth+1)
-
-		n.contentArray[nodeIdx] = unsafe.Pointer(n2)
-
+		before := t.len
+		n.nodes[nodeIdx] = t.replaceOrInsert(n.nodes[nodeIdx], keyHash, depth+1, e, pathCopy)
+		n.size += t.len - before
+
+	default:
+		// the block is empty, we can insert the entry directly
+		n.entries = slice.Insert(n.entries, n.entryIndex(mask), e)
+		n.dataMap |= mask
+		n.size++
+		t.len++
 	}
 
 	return n
 }
 
-func (m *PersistentHAMT[K, V]) delete(n *mapNode[K, V], k K, keyHash uint64, depth int, trueCount int32) (*mapNode[K, V], bool) {
-	refCount := atomic.LoadInt32(&n.refCount)
-	if refCount > 1 {
-		//pathCopy = true
-		n = n.shallowCloneWithRef()
-		trueCount += refCount - 1
-
-	}
+// alter finds the entry of k under the node n and replaces it by the entry returned by f in a single traversal.
+// f receives the current entry and true if k exists, the zero E and false otherwise. If f returns keep false, the
+// entry is removed, or nothing is inserted; if it returns the entry it received, nothing changes.
+// Nodes are copied lazily: a node is only copied once it is known to change and it is reachable from another
+// version, which is the case when pathCopy is true or its refCount is greater than 1.
+// If the returned node is not n, the caller must drop its reference to n and keep the returned node, which is nil
+// if n is left empty.
+func (t *trie[K, E]) alter(n *trieNode[K, E], k K, keyHash uint64, hk *HashedKey[K], depth int, f func(old E, ok bool) (e E, keep bool), pathCopy bool) *trieNode[K, E] {
 
-	//else if pathCopy {
-	//	fmt.Println("must shallow clone")
-	//	pathCopy = true
-	//	n = n.shallowCloneWithRef()
-	//}
+	pathCopy = pathCopy || atomic.LoadInt32(&n.refCount) > 1
 
 	level := depth % (exhaustedLevel + 1)
 	shift := level * arity
 	loc := bucket(keyHash, shift)
 
 	var mask uint64 = 1 << loc
-	recordIdx, nodeIdx := n.getContentIndexFromMask(mask)
-	// can't find the key to delete
-	if n.bitmap&mask == 0 {
-		return n, false
-	}
+	var zero E
+
+	switch {
+	case n.dataMap&mask != 0:
+		entryIdx := n.entryIndex(mask)
+		colEntry := n.entries[entryIdx]
+
+		if colEntry.entryKey() == k {
+			e, keep := f(colEntry, true)
+			if keep && e == colEntry {
+				return n
+			}
+
+			if pathCopy {
+				n = n.clone()
+			}
+
+			colEntry.decRef()
+			if keep {
+				n.entries[entryIdx] = e
+				return n
+			}
 
-	colRecord, n1 := (*record[K, V])(n.contentArray[recordIdx]), (*mapNode[K, V])(n.contentArray[nodeIdx])
-	if colRecord == nil {
+			n.entries = slice.RemoveAt(n.entries, entryIdx)
+			n.dataMap ^= mask
+			n.size--
+			t.len--
+			break
+		}
+
+		e, keep := f(zero, false)
+		if !keep {
+			return n
+		}
+
+		// collision, push both entries down to a new sub-node. The colliding entry moves with its reference.
+		colHash := t.hash(colEntry.entryKey(), depth)
 		if level == exhaustedLevel {
-			keyHash = m.hash(k, depth+1)
+			keyHash = t.keyHash(k, hk, depth+1)
+			colHash = t.hash(colEntry.entryKey(), depth+1)
 		}
-		var deleted bool
-		n1, deleted = m.delete(n1, k, keyHash, depth+1, trueCount)
-		n.contentArray[nodeIdx] = unsafe.Pointer(n1)
-		if n1 == nil {
-			n.contentArray = slice.RemoveRange(n.contentArray, recordIdx, nodeIdx+1)
-			n.bitmap ^= mask
-			if n.bitmap == 0 {
-				return nil, true
+		if pathCopy {
+			n = n.clone()
+		}
+		n1 := t.mergeEntries(keyHash, e, colHash, colEntry, depth+1)
+
+		n.entries = slice.RemoveAt(n.entries, entryIdx)
+		n.dataMap ^= mask
+		n.nodes = slice.Insert(n.nodes, n.nodeIndex(mask), n1)
+		n.nodeMap |= mask
+		n.size++
+		t.len++
+		return n
+
+	case n.nodeMap&mask != 0:
+		nodeIdx := n.nodeIndex(mask)
+		child := n.nodes[nodeIdx]
+
+		if level == exhaustedLevel {
+			keyHash = t.keyHash(k, hk, depth+1)
+		}
+
+		before := t.len
+		n1 := t.alter(child, k, keyHash, hk, depth+1, f, pathCopy)
+		single, isSingle := n1.singleEntry()
+		if n1 == child && !isSingle {
+			// unchanged, or changed in place. A sub-node left with a single entry still has to be inlined.
+			if t.len != before {
+				n.size += t.len - before
 			}
+			return n
 		}
 
-		return n, deleted
-	}
+		if pathCopy {
+			n = n.clone()
+		}
+		n.size += t.len - before
+		if n1 != child {
+			child.decRef()
+		}
 
-	if colRecord.key == k {
-		if trueCount >= 1 {
-			(*record[K, V])(n.contentArray[recordIdx]).decRef()
+		if n1 == nil {
+			n.nodes = slice.RemoveAt(n.nodes, nodeIdx)
+			n.nodeMap ^= mask
+		} else if isSingle {
+			// the sub-node is left with a single entry, inline it into this slot so the trie stays canonical
+			n.nodes = slice.RemoveAt(n.nodes, nodeIdx)
+			n.nodeMap ^= mask
+			n.entries = slice.Insert(n.entries, n.entryIndex(mask), single.incRef())
+			n.dataMap |= mask
+			n1.decRef()
+		} else {
+			n.nodes[nodeIdx] = n1
 		}
 
-		n.contentArray[recordIdx] = nil
-		n.contentArray = slice.RemoveRange(n.contentArray, recordIdx, nodeIdx+1)
-		n.bitmap ^= mask
-		m.len--
+	default:
+		e, keep := f(zero, false)
+		if !keep {
+			return n
+		}
 
-		// if there is only one record left, remove the node
-		if n.bitmap == 0 {
-			return nil, true
+		if pathCopy {
+			n = n.clone()
 		}
-		return n, true
+
+		n.entries = slice.Insert(n.entries, n.entryIndex(mask), e)
+		n.dataMap |= mask
+		n.size++
+		t.len++
+		return n
 	}
 
-	return n, false
+	if n.isEmpty() {
+		if pathCopy {
+			n.decRef()
+		}
+		return nil
+	}
+
+	return n
 }
 
-func (m *PersistentHAMT[K, V]) _range(n *mapNode[K, V], iter func(k K, v V) bool) bool {
+// alterRoot runs alter from the root of the trie with the key hashed once, or not at all if hk is not nil.
+func (t *trie[K, E]) alterRoot(k K, hk *HashedKey[K], f func(old E, ok bool) (e E, keep bool)) {
+	keyHash := t.keyHash(k, hk, 0)
+
+	if t.root == nil {
+		t.root = newTrieNode[K, E]()
+	}
+
+	if root := t.alter(t.root, k, keyHash, hk, 0, f, false); root != t.root {
+		t.root.decRef()
+		t.root = root
+	}
+}
+
+func (m *PersistentHAMT[K, V]) _range(n *trieNode[K, *record[K, V]], iter func(k K, v V) bool) bool {
 	if n == nil {
 		return false
 	}
 
-	for i := 0; i < len(n.contentArray)/2; i++ {
-		recordIdx := width * i
-		nodeIdx := width*i + 1
-
-		if n.contentArray[recordIdx] != nil {
-			//if f((*mapNode[K, V])(n.contentArray[nodeIdx])) {
-			//	return
-			//}
-			rec := (*record[K, V])(n.contentArray[recordIdx])
-			if iter(rec.key, rec.value) {
-				return true
-			}
-
+	for _, rec := range n.entries {
+		if iter(rec.key, rec.value) {
+			return true
 		}
+	}
 
-		if n.contentArray[nodeIdx] != nil {
-			if m._range((*mapNode[K, V])(n.contentArray[nodeIdx]), iter) {
-				return true
-			}
+	for _, child := range n.nodes {
+		if m._range(child, iter) {
+			return true
 		}
 	}
+
 	return false
 
 }
 
-func (m *PersistentHAMT[K, V]) get(n *mapNode[K, V], k K, keyHash uint64, depth int) (_ V, _ bool) {
+func (m *PersistentHAMT[K, V]) get(n *trieNode[K, *record[K, V]], k K, keyHash uint64, depth int) (_ V, _ bool) {
+	if r, ok := m.getEntry(n, k, keyHash, nil, depth); ok {
+		return r.value, true
+	}
+	return
+}
+
+// getEntry returns the entry of the key k under the node n and whether there is one.
+// keyHash is the hash of k for the given depth. If hk is not nil, it caches the hashes of k for the deeper levels.
+func (t *trie[K, E]) getEntry(n *trieNode[K, E], k K, keyHash uint64, hk *HashedKey[K], depth int) (e E, ok bool) {
 
 	level := depth % (exhaustedLevel + 1)
 	shift := level * arity
 
-	loc := bucket(keyHash, shift)
-
-	colRecord, n1 := n.TryGetBlock(loc)
-
-	if colRecord == nil && n1 == nil {
-		return
-	}
+	var mask uint64 = 1 << bucket(keyHash, shift)
 
-	if colRecord != nil {
-		if colRecord.key == k {
-			return colRecord.value, true
+	switch {
+	case n.dataMap&mask != 0:
+		if colEntry := n.entries[n.entryIndex(mask)]; colEntry.entryKey() == k {
+			return colEntry, true
 		}
 		return
+	case n.nodeMap&mask == 0:
+		return
 	}
 
 	if level == exhaustedLevel {
-		keyHash = m.hash(k, depth+1)
+		keyHash = t.keyHash(k, hk, depth+1)
 	}
-	return m.get(n1, k, keyHash, depth+1)
+	return t.getEntry(n.nodes[n.nodeIndex(mask)], k, keyHash, hk, depth+1)
 
 }
 
@@ -496,6 +535,7 @@ func (m *PersistentHAMT[K, V]) Set(k K, v V) {
 }
 
 func (m *PersistentHAMT[K, V]) Put(k K, v V, release func(key K, value V)) {
+	m.thaw()
 	keyHash := m.hash(k, 0)
 
 	if m.root == nil {
@@ -506,6 +546,9 @@ func (m *PersistentHAMT[K, V]) Put(k K, v V, release func(key K, value V)) {
 }
 
 func (m *PersistentHAMT[K, V]) Get(k K) (_ V, _ bool) {
+	if m.frozen != nil {
+		return m.frozen.Load(k)
+	}
 	if m.root == nil {
 		return
 	}
@@ -514,34 +557,98 @@ func (m *PersistentHAMT[K, V]) Get(k K) (_ V, _ bool) {
 	return m.get(m.root, k, keyHash, 0)
 }
 
+// Update calls f with the current value of k and whether k exists, then stores the value returned by f if keep is
+// true, or deletes k if keep is false. It returns the value of k after the update and whether k exists.
+// The key is hashed once and the path to the key is copied at most once.
+func (m *PersistentHAMT[K, V]) Update(k K, f func(old V, ok bool) (v V, keep bool)) (v V, ok bool) {
+	m.thaw()
+	m.alterRoot(k, nil, func(old *record[K, V], found bool) (*record[K, V], bool) {
+		var oldValue V
+		if found {
+			oldValue = old.value
+		}
+
+		newValue, keep := f(oldValue, found)
+		if !keep {
+			return nil, false
+		}
+
+		v, ok = newValue, true
+		return newRecord[K, V](k, newValue, nil), true
+	})
+
+	return
+}
+
+// Upsert stores the value returned by f for k, where f receives the current value of k and whether k exists.
+// It returns the stored value.
+func (m *PersistentHAMT[K, V]) Upsert(k K, f func(old V, ok bool) V) V {
+	v, _ := m.Update(k, func(old V, ok bool) (V, bool) {
+		return f(old, ok), true
+	})
+	return v
+}
+
+// GetOrInsert returns the value of k if it exists. Otherwise it stores the value returned by mk and returns it.
+// loaded is true if the value was already in the map. Nothing is copied if k exists.
+func (m *PersistentHAMT[K, V]) GetOrInsert(k K, mk func() V) (v V, loaded bool) {
+	m.thaw()
+	m.alterRoot(k, nil, func(old *record[K, V], found bool) (*record[K, V], bool) {
+		if found {
+			v, loaded = old.value, true
+			return old, true
+		}
+
+		v = mk()
+		return newRecord[K, V](k, v, nil), true
+	})
+
+	return
+}
+
 func (m *PersistentHAMT[K, V]) Clone() *PersistentHAMT[K, V] {
-	return &PersistentHAMT[K, V]{
-		root:   m.root.incRef(),
-		len:    m.len,
-		hasher: m.hasher,
-	}
+	c := &PersistentHAMT[K, V]{}
+	c.root, c.len, c.hasher = m.root.incRef(), m.len, m.hasher
+	c.frozen = m.frozen
+	return c
 }
 
 func (m *PersistentHAMT[K, V]) Delete(k K) bool {
+	return m.deleteKey(k, nil)
+}
+
+// deleteKey removes the key k with alter, hashing it with hk if it is not nil. It reports whether k was found.
+func (m *PersistentHAMT[K, V]) deleteKey(k K, hk *HashedKey[K]) bool {
+	m.thaw()
 	if m.root == nil {
 		return false
 	}
 
-	keyHash := m.hash(k, 0)
-	var deleted bool
-	m.root, deleted = m.delete(m.root, k, keyHash, 0, 1)
-	return deleted
+	before := m.len
+	m.alterRoot(k, hk, func(*record[K, V], bool) (*record[K, V], bool) {
+		return nil, false
+	})
+	return m.len != before
 }
 
 func (m *PersistentHAMT[K, V]) Range(f func(k K, v V) bool) {
+	if m.frozen != nil {
+		m.frozen.Range(f)
+		return
+	}
 	m._range(m.root, f)
 }
 
+// Clear removes every entry of the map. The records are released once no other version references them.
 func (m *PersistentHAMT[K, V]) Clear() {
 	m.root.decRef()
 	m.root = nil // GC
+	m.len = 0
+	m.frozen = nil
 }
 
+// Destroy releases the map. The release callback of a record runs once every version holding it is destroyed,
+// which can be checked in tests with the debug mode, see SetDebug.
 func (m *PersistentHAMT[K, V]) Destroy() {
 	m.Clear()
 }
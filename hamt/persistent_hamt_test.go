@@ -3,6 +3,7 @@ package hamt
 import (
 	"fmt"
 	"golang.org/x/exp/slices"
+	"math/bits"
 	"math/rand"
 	"reflect"
 	"sync/atomic"
@@ -10,12 +11,12 @@ import (
 	"unsafe"
 )
 
-//func dfs[K comparable, V any](t *testing.T, n *mapNode[K, V]) []*mapNode[K, V] {
+//func dfs[K comparable, V any](t *testing.T, n *trieNode[K, *record[K, V]]) []*trieNode[K, *record[K, V]] {
 //	t.Helper()
 //	return dfsRef(t, n)
 //}
 
-func dfsRef[K comparable, V any](t *testing.T, n *mapNode[K, V], f func(n *mapNode[K, V]) bool) {
+func dfsRef[K comparable, V any](t *testing.T, n *trieNode[K, *record[K, V]], f func(n *trieNode[K, *record[K, V]]) bool) {
 	t.Helper()
 	if n == nil {
 		return
@@ -25,14 +26,8 @@ func dfsRef[K comparable, V any](t *testing.T, n *mapNode[K, V], f func(n *mapNo
 		return
 	}
 
-	for i := 0; i < len(n.contentArray)/2; i++ {
-		nodeIdx := width*i + 1
-		if n.contentArray[nodeIdx] != nil {
-			//if f((*mapNode[K, V])(n.contentArray[nodeIdx])) {
-			//	return
-			//}
-			dfsRef(t, (*mapNode[K, V])(n.contentArray[nodeIdx]), f)
-		}
+	for _, child := range n.nodes {
+		dfsRef(t, child, f)
 	}
 
 	return
@@ -118,12 +113,12 @@ func TestPersistentHAMTInsertion(t *testing.T) {
 
 }
 
-func validateSharedNode[K comparable, V any](t *testing.T, trie *PersistentHAMT[K, V], clone *PersistentHAMT[K, V], expectedNodeShared int) []*mapNode[K, V] {
+func validateSharedNode[K comparable, V any](t *testing.T, trie *PersistentHAMT[K, V], clone *PersistentHAMT[K, V], expectedNodeShared int) []*trieNode[K, *record[K, V]] {
 	t.Helper()
 
-	var sharedNodes []*mapNode[K, V]
+	var sharedNodes []*trieNode[K, *record[K, V]]
 	var trieSharedNode []uintptr
-	dfsRef[K, V](t, trie.root, func(n *mapNode[K, V]) bool {
+	dfsRef[K, V](t, trie.root, func(n *trieNode[K, *record[K, V]]) bool {
 		if n.refCount > 1 {
 			sharedNodes = append(sharedNodes, n)
 			trieSharedNode = append(trieSharedNode, uintptr(unsafe.Pointer(n)))
@@ -133,7 +128,7 @@ func validateSharedNode[K comparable, V any](t *testing.T, trie *PersistentHAMT[
 
 	var cloneSharedNode []uintptr
 
-	dfsRef[K, V](t, clone.root, func(n *mapNode[K, V]) bool {
+	dfsRef[K, V](t, clone.root, func(n *trieNode[K, *record[K, V]]) bool {
 		if n.refCount > 1 {
 			cloneSharedNode = append(cloneSharedNode, uintptr(unsafe.Pointer(n)))
 		}
@@ -457,6 +452,268 @@ func TestPersistentHAMTDelete(t *testing.T) {
 	})
 }
 
+// sameShape reports whether the two nodes have identical bitmaps and keys at every level.
+func sameShape[K comparable, V any](t *testing.T, n1, n2 *trieNode[K, *record[K, V]]) bool {
+	t.Helper()
+	if n1 == nil || n2 == nil {
+		return n1 == n2
+	}
+
+	if n1.dataMap != n2.dataMap || n1.nodeMap != n2.nodeMap {
+		return false
+	}
+
+	for i := range n1.entries {
+		if n1.entries[i].key != n2.entries[i].key {
+			return false
+		}
+	}
+
+	for i := range n1.nodes {
+		if !sameShape(t, n1.nodes[i], n2.nodes[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestPersistentHAMTDeleteCompaction(t *testing.T) {
+	t.Run("Collision chain collapses", func(t *testing.T) {
+		trie := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		insertItem(t, trie, "c", 3, 1)
+		insertItem(t, trie, "d", 4, 2)
+
+		expected := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		insertItem(t, expected, "c", 3, 1)
+
+		clone := trie.Clone()
+		deleteItem(t, clone, "d", 1, true)
+
+		if !sameShape(t, clone.root, expected.root) {
+			t.Errorf("deleting a colliding key should inline the remaining record into the root")
+		}
+
+		// the original version keeps its deep shape
+		if sameShape(t, trie.root, expected.root) {
+			t.Errorf("trie should not be affected by a deletion in its clone")
+		}
+
+		if v, ok := trie.Get("d"); !ok || v != 4 {
+			t.Errorf("trie.Get(d) = %d, want %d", v, 4)
+		}
+	})
+
+	t.Run("Canonical after churn", func(t *testing.T) {
+		trie := NewPersistentHAMT[int, int](newIntHasher())
+		expected := NewPersistentHAMT[int, int](newIntHasher())
+
+		for i := 0; i < 5000; i++ {
+			trie.Set(i, i)
+		}
+
+		for i := 0; i < 5000; i++ {
+			if i%7 == 0 {
+				expected.Set(i, i)
+				continue
+			}
+			trie.Delete(i)
+		}
+
+		if trie.Len() != expected.Len() {
+			t.Errorf("trie.Len() = %d, want %d", trie.Len(), expected.Len())
+		}
+
+		if !sameShape(t, trie.root, expected.root) {
+			t.Errorf("tries holding the same keys should have the same shape")
+		}
+
+		dfsRef(t, trie.root, func(n *trieNode[int, *record[int, int]]) bool {
+			if _, single := n.singleEntry(); n != trie.root && single {
+				t.Errorf("found a sub-node holding a single record")
+				return true
+			}
+			return false
+		})
+	})
+}
+
+func TestPersistentHAMTUpdate(t *testing.T) {
+	t.Run("Basic Update", func(t *testing.T) {
+		trie := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+
+		for _, k := range []string{"a", "b", "c", "d", "rehash2time_1", "rehash2time_2"} {
+			if v := trie.Upsert(k, func(old int, ok bool) int { return old + 1 }); v != 1 {
+				t.Errorf("trie.Upsert(%s) = %d, want %d", k, v, 1)
+			}
+		}
+
+		clone := trie.Clone()
+		for _, k := range []string{"a", "c", "rehash2time_2"} {
+			if v := clone.Upsert(k, func(old int, ok bool) int { return old + 1 }); v != 2 {
+				t.Errorf("clone.Upsert(%s) = %d, want %d", k, v, 2)
+			}
+		}
+
+		for _, k := range []string{"b", "d", "rehash2time_1"} {
+			v, ok := clone.Update(k, func(old int, ok bool) (int, bool) { return 0, false })
+			if ok || v != 0 {
+				t.Errorf("clone.Update(%s) = %d, %v, want 0, false", k, v, ok)
+			}
+		}
+
+		if _, ok := clone.Update("e", func(old int, ok bool) (int, bool) { return 0, false }); ok || clone.Len() != 3 {
+			t.Errorf("removing a missing key should not change the map")
+		}
+
+		expected := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		expected.Set("a", 2)
+		expected.Set("c", 2)
+		expected.Set("rehash2time_2", 2)
+		if !Equal(clone, expected, intEq) || !sameShape(t, clone.root, expected.root) {
+			t.Errorf("clone = %v, want %v", toGoMap(clone), toGoMap(expected))
+		}
+
+		for _, k := range []string{"a", "b", "c", "d", "rehash2time_1", "rehash2time_2"} {
+			if v, ok := trie.Get(k); !ok || v != 1 {
+				t.Errorf("trie.Get(%s) = %d, want %d", k, v, 1)
+			}
+		}
+
+		for _, k := range []string{"a", "c", "rehash2time_2"} {
+			clone.Update(k, func(old int, ok bool) (int, bool) { return 0, false })
+		}
+		if clone.root != nil || clone.Len() != 0 {
+			t.Errorf("removing every key should leave an empty map")
+		}
+	})
+
+	t.Run("GetOrInsert", func(t *testing.T) {
+		trie := NewPersistentHAMT[int, int](newIntHasher())
+		for i := 0; i < 1000; i++ {
+			trie.Set(i, i)
+		}
+
+		clone := trie.Clone()
+		root := clone.root
+		for i := 0; i < 1000; i++ {
+			v, loaded := clone.GetOrInsert(i, func() int {
+				t.Fatalf("mk should not be called for an existing key")
+				return 0
+			})
+			if !loaded || v != i {
+				t.Errorf("clone.GetOrInsert(%d) = %d, %v, want %d, true", i, v, loaded, i)
+			}
+		}
+
+		if clone.root != root || root.refCount != 2 {
+			t.Errorf("GetOrInsert on existing keys should not copy any node")
+		}
+
+		if v, loaded := clone.GetOrInsert(1000, func() int { return 42 }); loaded || v != 42 {
+			t.Errorf("clone.GetOrInsert(1000) = %d, %v, want %d, false", v, loaded, 42)
+		}
+
+		if _, ok := trie.Get(1000); ok || clone.Len() != 1001 || trie.Len() != 1000 {
+			t.Errorf("GetOrInsert should only change the clone")
+		}
+	})
+
+	t.Run("Hash once", func(t *testing.T) {
+		hasher := &countingHasher[int]{Hasher: newIntHasher()}
+		trie := NewPersistentHAMT[int, int](hasher)
+		for i := 0; i < 1000; i++ {
+			trie.Set(i, i)
+		}
+
+		clone := trie.Clone()
+		for i := 0; i < 1000; i++ {
+			hasher.hashCount = 0
+			clone.Upsert(i, func(old int, ok bool) int { return old + 1 })
+			clone.GetOrInsert(i, func() int { return 0 })
+			clone.Update(i, func(old int, ok bool) (int, bool) { return old, i%2 == 0 })
+
+			if hasher.hashCount != 3 {
+				t.Fatalf("each operation should hash the key once, got %d hashes for 3 operations", hasher.hashCount)
+			}
+		}
+
+		if clone.Len() != 500 {
+			t.Errorf("clone.Len() = %d, want %d", clone.Len(), 500)
+		}
+	})
+
+	t.Run("Random Update", func(t *testing.T) {
+		deletedEntries := make(map[mapEntry]int)
+		m := &validatedMap{
+			impl:     NewPersistentHAMT[int, int](newIntHasher()),
+			expected: make(map[int]int),
+			deleted:  deletedEntries,
+			seen:     make(map[mapEntry]int),
+		}
+
+		versions := []*validatedMap{m}
+		for i := 0; i < 3000; i++ {
+			if i%500 == 499 {
+				m = m.clone()
+				versions = append(versions, m)
+			}
+
+			k := rand.Intn(1000)
+			switch rand.Intn(3) {
+			case 0:
+				m.impl.Update(k, func(old int, ok bool) (int, bool) {
+					if _, exists := m.expected[k]; exists != ok || (ok && old != m.expected[k]) {
+						t.Fatalf("Update(%d) received %d, %v", k, old, ok)
+					}
+					return 0, false
+				})
+				delete(m.expected, k)
+			case 1:
+				m.impl.Upsert(k, func(old int, ok bool) int { return old + 1 })
+				m.expected[k]++
+			default:
+				_, exists := m.expected[k]
+				if !exists {
+					m.expected[k] = k
+				}
+
+				v, loaded := m.impl.GetOrInsert(k, func() int { return k })
+				if loaded != exists || v != m.expected[k] {
+					t.Fatalf("GetOrInsert(%d) = %d, %v, want %d, %v", k, v, loaded, m.expected[k], exists)
+				}
+			}
+		}
+
+		for _, v := range versions {
+			v.validate(t)
+			if v.impl.Len() != len(v.expected) {
+				t.Fatalf("Len() = %d, want %d", v.impl.Len(), len(v.expected))
+			}
+		}
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		released := 0
+		trie := NewPersistentHAMT[int, int](newIntHasher())
+		trie.Put(1, 1, func(k int, v int) { released++ })
+		trie.Put(2, 2, func(k int, v int) { released++ })
+
+		clone := trie.Clone()
+		clone.Upsert(1, func(old int, ok bool) int { return old + 1 })
+		clone.Update(2, func(old int, ok bool) (int, bool) { return 0, false })
+		if released != 0 {
+			t.Errorf("records still referenced by trie should not be released")
+		}
+
+		trie.Destroy()
+		if released != 2 {
+			t.Errorf("released = %d, want %d", released, 2)
+		}
+		clone.Destroy()
+	})
+}
+
 // test cases from golang.org/x/tools/internal/persistent
 type mapEntry struct {
 	key   int
@@ -531,10 +788,17 @@ func TestSimpleMap(t *testing.T) {
 
 	keyhash1 := m1.impl.hash(100, 0)
 	keyhash2 := m1.impl.hash(1, 0)
+	remove := func(*record[int, int], bool) (*record[int, int], bool) { return nil, false }
+	del := func(k int, keyHash uint64) {
+		if root := m1.impl.alter(m1.impl.root, k, keyHash, nil, 0, remove, false); root != m1.impl.root {
+			m1.impl.root.decRef()
+			m1.impl.root = root
+		}
+	}
 	gotAllocs := int(testing.AllocsPerRun(10, func() {
 		// Can not test this on m1.impl.Delete because the hash function's allocs are not deterministic.
-		m1.impl.root, _ = m1.impl.delete(m1.impl.root, 100, keyhash1, 0, 1)
-		m1.impl.root, _ = m1.impl.delete(m1.impl.root, 1, keyhash2, 0, 1)
+		del(100, keyhash1)
+		del(1, keyhash2)
 	}))
 	wantAllocs := 0
 	if gotAllocs != wantAllocs {
@@ -619,25 +883,18 @@ func TestRandomMap(t *testing.T) {
 	assertSameMap(t, entrySet(seenEntries), entrySet(deletedEntries))
 }
 
-func dumpMap(t *testing.T, prefix string, n *mapNode[int, int]) {
+func dumpMap(t *testing.T, prefix string, n *trieNode[int, *record[int, int]]) {
 
 	if n == nil {
 		t.Logf("%s nil", prefix)
 		return
 	}
-	//t.Logf("%s {key: %v, value: %v (ref: %v), ref: %v, weight: %v}", prefix, n.key, n.value.value, n.value.refCount, n.refCount)
-	for i := 0; i < len(n.contentArray)/2; i++ {
-		recordIdx := width * i
-		nodeIdx := width*i + 1
+	//for i, r := range n.entries {
+	//	t.Logf("%s %d {key : %v , value : %v}", prefix, i, r.key, r.value)
+	//}
 
-		if n.contentArray[recordIdx] != nil {
-			//r := (*record[int, int])(n.contentArray[recordIdx])
-			//t.Logf("%s %d {key : %v , value : %v}", prefix, i, r.key, r.value)
-		}
-
-		if n.contentArray[nodeIdx] != nil {
-			dumpMap(t, prefix+"->", (*mapNode[int, int])(n.contentArray[nodeIdx]))
-		}
+	for _, child := range n.nodes {
+		dumpMap(t, prefix+"->", child)
 	}
 
 }
@@ -650,64 +907,6 @@ func entrySet(m map[mapEntry]int) map[mapEntry]struct{} {
 	return set
 }
 
-//
-//func validateRef(t *testing.T, maps ...*validatedMap) {
-//	t.Helper()
-//
-//	actualCountByEntry := make(map[mapEntry]int32)
-//	nodesByEntry := make(map[mapEntry]map[*mapNode[int, int]]struct{})
-//	expectedCountByEntry := make(map[mapEntry]int32)
-//	for i, m := range maps {
-//		dfsRefV2(m.impl.root, actualCountByEntry, nodesByEntry, 1)
-//		dumpMap(t, fmt.Sprintf("%d: root ->", i), m.impl.root)
-//	}
-//	for entry, nodes := range nodesByEntry {
-//		expectedCountByEntry[entry] = int32(len(nodes))
-//	}
-//	assertSameMap(t, expectedCountByEntry, actualCountByEntry)
-//}
-//
-//func dfsRefV2(node *mapNode[int, int], countByEntry map[mapEntry]int32, nodesByEntry map[mapEntry]map[*mapNode[int, int]]struct{}, trueCount int32) {
-//	if node == nil {
-//		return
-//	}
-//
-//	if count := atomic.LoadInt32(&node.refCount); count > 1 {
-//		trueCount += count - 1
-//	}
-//
-//	for i := 0; i < len(node.contentArray)/2; i++ {
-//		recordIdx := width * i
-//		nodeIdx := width*i + 1
-//
-//		if node.contentArray[recordIdx] != nil {
-//			r := (*record[int, int])(node.contentArray[recordIdx])
-//			entry := mapEntry{key: r.key, value: r.value}
-//
-//			count := atomic.LoadInt32(&r.refCount)
-//			countByEntry[entry] = trueCount + count - 1
-//
-//			nodes, ok := nodesByEntry[entry]
-//			if !ok {
-//				nodes = make(map[*mapNode[int, int]]struct{})
-//				nodesByEntry[entry] = nodes
-//			}
-//			nodes[node] = struct{}{}
-//			//if count := atomic.LoadInt32(&r.refCount); count > 1 {
-//			//	countByEntry[entry] = trueCount + count - 1
-//			//} else {
-//			//	countByEntry[entry] = trueCount
-//			//}
-//
-//		}
-//		if node.contentArray[nodeIdx] != nil {
-//			dfsRefV2((*mapNode[int, int])(node.contentArray[nodeIdx]), countByEntry, nodesByEntry, trueCount)
-//		}
-//
-//	}
-//
-//}
-
 func validateRefV3(t *testing.T, maps ...*validatedMap) {
 	t.Helper()
 
@@ -728,7 +927,7 @@ func validateRefV3(t *testing.T, maps ...*validatedMap) {
 	assertSameMap(t, expectedCountByEntry, assumingCountByEntry)
 }
 
-func dfsRefV3(hmap *PersistentHAMT[int, int], node *mapNode[int, int], assumingCountByEntry map[mapEntry]int32, actualRefByEntry map[mapEntry]map[*PersistentHAMT[int, int]]struct{}, trueCount int32) {
+func dfsRefV3(hmap *PersistentHAMT[int, int], node *trieNode[int, *record[int, int]], assumingCountByEntry map[mapEntry]int32, actualRefByEntry map[mapEntry]map[*PersistentHAMT[int, int]]struct{}, trueCount int32) {
 	if node == nil {
 		return
 	}
@@ -737,30 +936,22 @@ func dfsRefV3(hmap *PersistentHAMT[int, int], node *mapNode[int, int], assumingC
 		trueCount += count - 1
 	}
 
-	for i := 0; i < len(node.contentArray)/2; i++ {
-		recordIdx := width * i
-		nodeIdx := width*i + 1
-
-		if node.contentArray[recordIdx] != nil {
-			r := (*record[int, int])(node.contentArray[recordIdx])
-			entry := mapEntry{key: r.key, value: r.value}
+	for _, r := range node.entries {
+		entry := mapEntry{key: r.key, value: r.value}
 
-			count := atomic.LoadInt32(&r.refCount)
-			assumingCountByEntry[entry] = trueCount + count - 1
+		count := atomic.LoadInt32(&r.refCount)
+		assumingCountByEntry[entry] = trueCount + count - 1
 
-			refs, ok := actualRefByEntry[entry]
-			if !ok {
-				refs = make(map[*PersistentHAMT[int, int]]struct{})
-				actualRefByEntry[entry] = refs
-			}
-			refs[hmap] = struct{}{}
-
-		}
-		//fmt.Println("node by entry sir: ", actualRefByEntry)
-		if node.contentArray[nodeIdx] != nil {
-			dfsRefV3(hmap, (*mapNode[int, int])(node.contentArray[nodeIdx]), assumingCountByEntry, actualRefByEntry, trueCount)
+		refs, ok := actualRefByEntry[entry]
+		if !ok {
+			refs = make(map[*PersistentHAMT[int, int]]struct{})
+			actualRefByEntry[entry] = refs
 		}
+		refs[hmap] = struct{}{}
+	}
 
+	for _, child := range node.nodes {
+		dfsRefV3(hmap, child, assumingCountByEntry, actualRefByEntry, trueCount)
 	}
 
 }
@@ -813,41 +1004,40 @@ func (vm *validatedMap) validate(t *testing.T) {
 	assertSameMap(t, actualMap, vm.expected)
 }
 
-func validateNode(t *testing.T, node *mapNode[int, int]) {
+func validateNode[K comparable, E entry[K, E]](t *testing.T, node *trieNode[K, E]) {
 	if node == nil {
 		return
 	}
 
-	if node.contentArray == nil {
-		t.Fatalf("node content array is nil")
+	if node.isEmpty() {
+		t.Fatalf("node bitmap is 0")
 	}
 
-	if len(node.contentArray)%2 != 0 {
-		t.Fatalf("node content array length is not even")
+	if node.dataMap&node.nodeMap != 0 {
+		t.Fatalf("node data map and node map overlap")
 	}
 
-	if node.bitmap == 0 {
-		t.Fatalf("node bitmap is 0")
+	if len(node.entries) != bits.OnesCount64(node.dataMap) || len(node.nodes) != bits.OnesCount64(node.nodeMap) {
+		t.Fatalf("node arrays don't match the bitmaps")
 	}
 
-	hasValue := false
-	for i := 0; i < len(node.contentArray)/2; i++ {
-
-		recordIdx := width * i
-		nodeIdx := width*i + 1
-
-		if node.contentArray[recordIdx] != nil || node.contentArray[nodeIdx] != nil {
-			hasValue = true
+	for _, e := range node.entries {
+		if v := reflect.ValueOf(e); v.Kind() == reflect.Pointer && v.IsNil() {
+			t.Fatalf("node has a nil record")
 		}
+	}
 
-		if node.contentArray[nodeIdx] != nil {
-			validateNode(t, (*mapNode[int, int])(node.contentArray[nodeIdx]))
+	size := len(node.entries)
+	for _, child := range node.nodes {
+		if child == nil {
+			t.Fatalf("node has a nil sub-node")
 		}
-
+		validateNode(t, child)
+		size += child.size
 	}
 
-	if !hasValue {
-		t.Fatalf("node has no value")
+	if node.size != size {
+		t.Fatalf("node size is %d, the sub-trie holds %d records", node.size, size)
 	}
 
 }
@@ -889,3 +1079,89 @@ func assertSameMap(t *testing.T, map1, map2 interface{}) {
 		t.Fatalf("different maps:\n%v\nvs\n%v", map1, map2)
 	}
 }
+
+var benchSizes = []int{1000, 100000}
+
+func benchmarkMap(n int) (*PersistentHAMT[int, int], []int) {
+	trie := NewPersistentHAMT[int, int](newIntHasher())
+	keys := make([]int, n)
+	for i := 0; i < n; i++ {
+		keys[i] = i
+		trie.Set(i, i)
+	}
+	return trie, keys
+}
+
+func BenchmarkPersistentHAMTSet(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				trie := NewPersistentHAMT[int, int](newIntHasher())
+				for k := 0; k < size; k++ {
+					trie.Set(k, k)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPersistentHAMTSetClone(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			trie, keys := benchmarkMap(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				clone := trie.Clone()
+				clone.Set(keys[i%len(keys)], i)
+				clone.Destroy()
+			}
+		})
+	}
+}
+
+func BenchmarkPersistentHAMTGet(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			trie, keys := benchmarkMap(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				trie.Get(keys[i%len(keys)])
+			}
+		})
+	}
+}
+
+func BenchmarkPersistentHAMTDelete(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			trie, keys := benchmarkMap(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				clone := trie.Clone()
+				clone.Delete(keys[i%len(keys)])
+				clone.Destroy()
+			}
+		})
+	}
+}
+
+func BenchmarkPersistentHAMTRange(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			trie, _ := benchmarkMap(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				sum := 0
+				trie.Range(func(k int, v int) bool {
+					sum += v
+					return false
+				})
+			}
+		})
+	}
+}
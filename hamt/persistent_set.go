@@ -0,0 +1,190 @@
+package hamt
+
+import "math/bits"
+
+// setKey is the entry of a PersistentSet: the key alone, stored inline in the nodes. Keys are copied with the nodes,
+// so they need no reference counting.
+type setKey[K comparable] struct {
+	key K
+}
+
+func (e setKey[K]) entryKey() K {
+	return e.key
+}
+
+func (e setKey[K]) incRef() setKey[K] {
+	return e
+}
+
+func (e setKey[K]) decRef() {}
+
+// PersistentSet is a persistent set of keys. It uses the same trie as PersistentHAMT, but its nodes store the keys
+// themselves instead of records, so a key costs no allocation and no reference count.
+// Like PersistentHAMT, a PersistentSet is a version that can be modified in place; Clone returns a new version in
+// O(1) and the versions share their nodes until one of them is modified.
+type PersistentSet[K comparable] struct {
+	trie[K, setKey[K]]
+}
+
+func NewPersistentSet[K comparable](h Hasher[K]) *PersistentSet[K] {
+	s := &PersistentSet[K]{}
+	s.hasher = h
+	s.root = newTrieNode[K, setKey[K]]()
+	return s
+}
+
+func (s *PersistentSet[K]) Len() int {
+	return s.len
+}
+
+// Add adds the key k to the set. It returns false if k is already in the set.
+func (s *PersistentSet[K]) Add(k K) bool {
+	before := s.len
+	s.alterRoot(k, nil, func(old setKey[K], ok bool) (setKey[K], bool) {
+		if ok {
+			return old, true
+		}
+		return setKey[K]{key: k}, true
+	})
+	return s.len != before
+}
+
+// Remove removes the key k from the set. It returns false if k is not in the set.
+func (s *PersistentSet[K]) Remove(k K) bool {
+	if s.root == nil {
+		return false
+	}
+
+	before := s.len
+	s.alterRoot(k, nil, func(setKey[K], bool) (setKey[K], bool) {
+		return setKey[K]{}, false
+	})
+	return s.len != before
+}
+
+func (s *PersistentSet[K]) Contains(k K) bool {
+	if s.root == nil {
+		return false
+	}
+
+	_, ok := s.getEntry(s.root, k, s.hash(k, 0), nil, 0)
+	return ok
+}
+
+// Range calls f for every key of the set, in no particular order. If f returns true, Range stops.
+func (s *PersistentSet[K]) Range(f func(k K) bool) {
+	rangeEntries(s.root, func(e setKey[K]) bool {
+		return f(e.key)
+	})
+}
+
+// Keys returns the keys of the set in no particular order.
+func (s *PersistentSet[K]) Keys() []K {
+	keys := make([]K, 0, s.len)
+	s.Range(func(k K) bool {
+		keys = append(keys, k)
+		return false
+	})
+	return keys
+}
+
+func (s *PersistentSet[K]) Clone() *PersistentSet[K] {
+	c := &PersistentSet[K]{}
+	c.root, c.len, c.hasher = s.root.incRef(), s.len, s.hasher
+	return c
+}
+
+func (s *PersistentSet[K]) Clear() {
+	s.root.decRef()
+	s.root = nil // GC
+	s.len = 0
+}
+
+func (s *PersistentSet[K]) Destroy() {
+	s.Clear()
+}
+
+// Union returns a new set holding the keys of both s and other.
+// The two tries are combined node by node and sub-tries found on one side only are shared with the result, see Merge.
+// Both sets must hash their keys the same way; the result uses the hasher of s.
+func (s *PersistentSet[K]) Union(other *PersistentSet[K]) *PersistentSet[K] {
+	u := &PersistentSet[K]{}
+	u.hasher = s.hasher
+
+	common := 0
+	u.root = u.union(nodeOrEmpty(s.root), nodeOrEmpty(other.root), 0, nil, &common)
+	u.len = s.len + other.len - common
+	return u
+}
+
+// Intersection returns a new set holding the keys that are in both s and other.
+// Both sets must hash their keys the same way; the result uses the hasher of s.
+func (s *PersistentSet[K]) Intersection(other *PersistentSet[K]) *PersistentSet[K] {
+	i := &PersistentSet[K]{}
+	i.hasher = s.hasher
+
+	i.root = i.intersect(nodeOrEmpty(s.root), nodeOrEmpty(other.root), 0, nil, &i.len)
+	return i
+}
+
+// Difference returns a new set holding the keys of s that are not in other.
+// Both sets must hash their keys the same way; the result uses the hasher of s.
+func (s *PersistentSet[K]) Difference(other *PersistentSet[K]) *PersistentSet[K] {
+	d := &PersistentSet[K]{}
+	d.hasher = s.hasher
+
+	removed := 0
+	d.root = d.subtract(nodeOrEmpty(s.root), nodeOrEmpty(other.root), 0, &removed)
+	d.len = s.len - removed
+	return d
+}
+
+// IsSubset reports whether every key of s is also in other.
+// Both sets must hash their keys the same way. Sub-tries shared by the two sets are not visited.
+func (s *PersistentSet[K]) IsSubset(other *PersistentSet[K]) bool {
+	if s.Len() > other.Len() {
+		return false
+	}
+
+	return s.hasKeysOf(nodeOrEmpty(other.root), nodeOrEmpty(s.root), 0)
+}
+
+// hasKeysOf reports whether every key under n2 is also under n1, both found at the given depth.
+func (t *trie[K, E]) hasKeysOf(n1, n2 *trieNode[K, E], depth int) bool {
+	if n1 == n2 {
+		return true
+	}
+	if n2.size > n1.size {
+		return false
+	}
+
+	for used := n2.dataMap | n2.nodeMap; used != 0; used &= used - 1 {
+		var mask uint64 = 1 << bits.TrailingZeros64(used)
+
+		switch {
+		case n2.dataMap&mask != 0:
+			k := n2.entries[n2.entryIndex(mask)].entryKey()
+			switch {
+			case n1.dataMap&mask != 0:
+				if n1.entries[n1.entryIndex(mask)].entryKey() != k {
+					return false
+				}
+			case n1.nodeMap&mask != 0:
+				if _, ok := t.getEntry(n1.nodes[n1.nodeIndex(mask)], k, t.hash(k, depth+1), nil, depth+1); !ok {
+					return false
+				}
+			default:
+				return false
+			}
+		case n1.nodeMap&mask != 0:
+			if !t.hasKeysOf(n1.nodes[n1.nodeIndex(mask)], n2.nodes[n2.nodeIndex(mask)], depth+1) {
+				return false
+			}
+		default:
+			// a sub-node holds at least two keys, it is not covered by a single key or an empty slot
+			return false
+		}
+	}
+
+	return true
+}
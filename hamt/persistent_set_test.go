@@ -0,0 +1,204 @@
+package hamt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func validateSet[K comparable](t *testing.T, s *PersistentSet[K], expected map[K]struct{}) {
+	t.Helper()
+
+	if root := s.root; root != nil && !root.isEmpty() {
+		validateNode(t, root)
+	}
+
+	if s.Len() != len(expected) {
+		t.Fatalf("s.Len() = %d, want %d", s.Len(), len(expected))
+	}
+
+	actual := make(map[K]struct{})
+	s.Range(func(k K) bool {
+		if _, ok := actual[k]; ok {
+			t.Fatalf("key %v is present twice", k)
+		}
+		actual[k] = struct{}{}
+		return false
+	})
+	assertSameMap(t, actual, expected)
+
+	for k := range expected {
+		if !s.Contains(k) {
+			t.Fatalf("s.Contains(%v) = false, want true", k)
+		}
+	}
+}
+
+func randomSet(n, keyRange int) (*PersistentSet[int], map[int]struct{}) {
+	s := NewPersistentSet[int](newIntHasher())
+	expected := make(map[int]struct{})
+	for i := 0; i < n; i++ {
+		k := rand.Intn(keyRange)
+		s.Add(k)
+		expected[k] = struct{}{}
+	}
+	return s, expected
+}
+
+func TestPersistentSet(t *testing.T) {
+	t.Run("Basic Set", func(t *testing.T) {
+		s := NewPersistentSet[string](newCollisionHasher[string]())
+		expected := make(map[string]struct{})
+
+		for _, k := range []string{"a", "b", "c", "d", "e", "rehash2time_1", "rehash2time_2", "fullhash_1", "fullhash_1_collision"} {
+			if !s.Add(k) {
+				t.Errorf("s.Add(%s) = false, want true", k)
+			}
+			expected[k] = struct{}{}
+		}
+
+		if s.Add("a") {
+			t.Errorf("adding an existing key should return false")
+		}
+		validateSet(t, s, expected)
+
+		if s.Contains("panic1") {
+			t.Errorf("s.Contains(panic1) = true, want false")
+		}
+
+		clone := s.Clone()
+		for k := range expected {
+			if !clone.Remove(k) {
+				t.Errorf("clone.Remove(%s) = false, want true", k)
+			}
+		}
+
+		if clone.Remove("a") {
+			t.Errorf("removing a missing key should return false")
+		}
+
+		validateSet(t, clone, map[string]struct{}{})
+		validateSet(t, s, expected)
+	})
+
+	t.Run("Random Set with clones", func(t *testing.T) {
+		s, expected := randomSet(5000, 10000)
+		versions := []*PersistentSet[int]{s}
+		expectedVersions := []map[int]struct{}{expected}
+
+		for v := 0; v < 5; v++ {
+			s = s.Clone()
+			next := make(map[int]struct{})
+			for k := range expected {
+				next[k] = struct{}{}
+			}
+
+			for i := 0; i < 2000; i++ {
+				k := rand.Intn(10000)
+				if rand.Intn(2) == 0 {
+					_, ok := next[k]
+					if s.Add(k) == ok {
+						t.Fatalf("s.Add(%d) = %v, want %v", k, ok, !ok)
+					}
+					next[k] = struct{}{}
+				} else {
+					_, ok := next[k]
+					if s.Remove(k) != ok {
+						t.Fatalf("s.Remove(%d) = %v, want %v", k, !ok, ok)
+					}
+					delete(next, k)
+				}
+			}
+
+			versions = append(versions, s)
+			expectedVersions = append(expectedVersions, next)
+			expected = next
+		}
+
+		for i, v := range versions {
+			validateSet(t, v, expectedVersions[i])
+		}
+
+		for _, v := range versions {
+			v.Destroy()
+		}
+	})
+
+	t.Run("Keys only", func(t *testing.T) {
+		SetDebug(true)
+		t.Cleanup(func() { SetDebug(false) })
+
+		records, nodes := LiveRecords(), LiveNodes()
+		s, _ := randomSet(1000, 10000)
+		empty := NewPersistentSet[int](newIntHasher())
+		u := s.Union(empty)
+
+		if n := LiveRecords() - records; n != 0 {
+			t.Errorf("the set allocated %d records, want 0", n)
+		}
+
+		for _, v := range []*PersistentSet[int]{s, empty, u} {
+			v.Destroy()
+		}
+		if n := LiveNodes() - nodes; n != 0 {
+			t.Errorf("%d nodes are still alive after destroying the sets", n)
+		}
+	})
+}
+
+func TestPersistentSetOperations(t *testing.T) {
+	a, ea := randomSet(3000, 5000)
+	b, eb := randomSet(3000, 5000)
+
+	union := make(map[int]struct{})
+	intersection := make(map[int]struct{})
+	difference := make(map[int]struct{})
+	for k := range ea {
+		union[k] = struct{}{}
+		if _, ok := eb[k]; ok {
+			intersection[k] = struct{}{}
+		} else {
+			difference[k] = struct{}{}
+		}
+	}
+	for k := range eb {
+		union[k] = struct{}{}
+	}
+
+	validateSet(t, a.Union(b), union)
+	validateSet(t, a.Intersection(b), intersection)
+	validateSet(t, a.Difference(b), difference)
+	validateSet(t, b.Union(a), union)
+	validateSet(t, b.Intersection(a), intersection)
+
+	validateSet(t, a, ea)
+	validateSet(t, b, eb)
+
+	if a.IsSubset(b) || b.IsSubset(a) {
+		t.Errorf("random sets should not be subsets of each other")
+	}
+
+	if !a.Intersection(b).IsSubset(a) || !a.Intersection(b).IsSubset(b) {
+		t.Errorf("the intersection should be a subset of both sets")
+	}
+
+	if !a.IsSubset(a.Union(b)) || !a.Difference(b).IsSubset(a) {
+		t.Errorf("a should be a subset of the union")
+	}
+
+	clone := a.Clone()
+	clone.Add(-1)
+	if !a.IsSubset(clone) || clone.IsSubset(a) {
+		t.Errorf("a should be a subset of its clone with an extra key")
+	}
+
+	empty := NewPersistentSet[int](newIntHasher())
+	if !empty.IsSubset(a) || a.IsSubset(empty) {
+		t.Errorf("the empty set is a subset of every set")
+	}
+
+	if u := a.Union(clone); u.root.nodes[0] != clone.root.nodes[0] {
+		t.Errorf("the union should share sub-tries with the input sets")
+	}
+
+	validateSet(t, a.Difference(a.Clone()), map[int]struct{}{})
+}
@@ -6,16 +6,27 @@ type record[K comparable, V any] struct {
 	key      K
 	value    V
 	refCount int32 // atomic
+	debug    bool  // created in debug mode
 	release  func(key K, value V)
 }
 
 func newRecord[K comparable, V any](k K, v V, release func(key K, value V)) *record[K, V] {
-	return &record[K, V]{
+	r := &record[K, V]{
 		key:      k,
 		value:    v,
 		refCount: 1, // atomic
 		release:  release,
 	}
+
+	if debugOn() {
+		r.debug = true
+		atomic.AddInt64(&liveRecords, 1)
+	}
+	return r
+}
+
+func (r *record[K, V]) entryKey() K {
+	return r.key
 }
 
 func (r *record[K, V]) incRef() *record[K, V] {
@@ -29,7 +40,12 @@ func (r *record[K, V]) incRef() *record[K, V] {
 func (r *record[K, V]) decRef() {
 	if r != nil {
 
-		if atomic.AddInt32(&r.refCount, -1) == 0 {
+		refCount := atomic.AddInt32(&r.refCount, -1)
+		if r.debug {
+			debugRelease(refCount, &liveRecords, "record")
+		}
+
+		if refCount == 0 {
 			if r.release != nil {
 				r.release(r.key, r.value)
 				r.release = nil
@@ -0,0 +1,191 @@
+package hamt_test
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/nnhatnam/immutable/hamt"
+	"github.com/nnhatnam/immutable/hamt/hamttest"
+)
+
+func TestReleaseTracking(t *testing.T) {
+	h := hamt.NewDefaultHasher[int]()
+
+	t.Run("Versions", func(t *testing.T) {
+		tr := hamttest.NewReleaseTracker(t)
+		records, nodes := hamt.LiveRecords(), hamt.LiveNodes()
+
+		m := hamt.NewPersistentHAMT[int, int](h)
+		versions := []*hamt.PersistentHAMT[int, int]{m}
+		for i := 0; i < 5000; i++ {
+			k := rand.Intn(1000)
+			switch rand.Intn(4) {
+			case 0, 1:
+				m.Put(k, i, hamttest.Release[int, int](tr))
+			case 2:
+				m.Delete(k)
+			default:
+				m.Update(k, func(old int, ok bool) (int, bool) { return old + 1, ok && old%2 == 0 })
+			}
+
+			if i%500 == 0 {
+				m = m.Clone()
+				versions = append(versions, m)
+			}
+		}
+
+		if hamt.LiveRecords() == records || hamt.LiveNodes() == nodes {
+			t.Fatalf("the live records and nodes should be counted")
+		}
+
+		for _, v := range versions {
+			v.Destroy()
+		}
+		tr.AssertReleased()
+	})
+
+	t.Run("Derived", func(t *testing.T) {
+		tr := hamttest.NewReleaseTracker(t)
+
+		a := hamt.NewPersistentHAMT[int, int](h)
+		b := hamt.NewPersistentHAMT[int, int](h)
+		for i := 0; i < 2000; i++ {
+			a.Put(i, i, hamttest.Release[int, int](tr))
+			b.Put(i+1000, i, hamttest.Release[int, int](tr))
+		}
+
+		c := hamt.NewCtrie[int, int](h)
+		for i := 0; i < 100; i++ {
+			c.Store(i, i)
+		}
+		c.Delete(50)
+
+		clone := a.Clone()
+		derived := []*hamt.PersistentHAMT[int, int]{
+			clone,
+			hamt.Merge(a, b, nil),
+			hamt.Merge(a, b, func(k, va, vb int) int { return va }),
+			hamt.Intersect(a, b, nil),
+			hamt.Subtract(a, b),
+			hamt.Subtract(a, clone),
+			hamt.Filter(a, func(k, v int) bool { return k%2 == 0 }),
+			hamt.MapValues[int, int, int](b, func(k, v int) int { return -v }, h),
+			c.ToPersistentHAMT(),
+		}
+
+		a.Destroy()
+		b.Destroy()
+		for _, m := range derived {
+			m.Destroy()
+		}
+		tr.AssertReleased()
+	})
+
+	t.Run("Decoded", func(t *testing.T) {
+		tr := hamttest.NewReleaseTracker(t)
+
+		src := make(map[int]int)
+		for i := 0; i < 1000; i++ {
+			src[i] = i
+		}
+		m := hamt.FromMap[int, int](h, src)
+		empty := hamt.FromMap[int, int](h, nil)
+
+		jsonData, _ := m.MarshalJSON()
+		gobData, _ := m.GobEncode()
+		binaryData, _ := m.MarshalBinary()
+
+		decoders := map[string]func(d *hamt.PersistentHAMT[int, int]) error{
+			"UnmarshalJSON":   func(d *hamt.PersistentHAMT[int, int]) error { return d.UnmarshalJSON(jsonData) },
+			"GobDecode":       func(d *hamt.PersistentHAMT[int, int]) error { return d.GobDecode(gobData) },
+			"UnmarshalBinary": func(d *hamt.PersistentHAMT[int, int]) error { return d.UnmarshalBinary(binaryData) },
+		}
+
+		decoded := []*hamt.PersistentHAMT[int, int]{m, empty}
+		for name, decode := range decoders {
+			// into a new map, into a zero map, and twice into the same map
+			d1, d2 := hamt.NewPersistentHAMT[int, int](h), &hamt.PersistentHAMT[int, int]{}
+			for _, d := range []*hamt.PersistentHAMT[int, int]{d1, d2, d2} {
+				if err := decode(d); err != nil {
+					t.Fatalf("%s() error = %v", name, err)
+				}
+			}
+			decoded = append(decoded, d1, d2)
+		}
+
+		for _, d := range decoded {
+			d.Destroy()
+		}
+		tr.AssertReleased()
+	})
+
+	t.Run("DeleteAll", func(t *testing.T) {
+		tr := hamttest.NewReleaseTracker(t)
+
+		m := hamt.NewPersistentHAMT[string, int](hamt.NewDefaultHasher[string]())
+		for i := 0; i < 1000; i++ {
+			m.Put(strconv.Itoa(rand.Int()), i, hamttest.Release[string, int](tr))
+		}
+		clone := m.Clone()
+
+		for _, k := range m.Keys() {
+			m.Delete(k)
+		}
+		clone.Destroy()
+		m.Destroy()
+		tr.AssertReleased()
+	})
+
+	t.Run("MultiMap", func(t *testing.T) {
+		tr := hamttest.NewReleaseTracker(t)
+
+		m := hamt.NewMultiMap[int, int](h, h)
+		versions := []*hamt.MultiMap[int, int]{m}
+		for i := 0; i < 2000; i++ {
+			m.Add(i%100, i)
+			if i%3 == 0 {
+				m.Remove(i%100, i-30)
+			}
+			if i%500 == 0 {
+				m = m.Clone()
+				versions = append(versions, m)
+			}
+		}
+
+		for _, v := range versions {
+			v.Destroy()
+		}
+		tr.AssertReleased()
+	})
+
+	t.Run("VersionedMap", func(t *testing.T) {
+		tr := hamttest.NewReleaseTracker(t)
+		m := hamt.NewVersionedMap[int, int](h, 3)
+
+		released := 0
+		for version := 0; version < 10; version++ {
+			for k := 0; k < 100; k++ {
+				release := hamttest.Release[int, int](tr)
+				m.Put(k, version, func(key, value int) {
+					released++
+					release(key, value)
+				})
+			}
+			m.Commit()
+
+			// every version overwrites every key, so the records of the evicted versions are released
+			if want := (version - 2) * 100; version >= 3 && released != want {
+				t.Fatalf("%d records were released after committing version %d, want %d", released, version+1, want)
+			}
+		}
+
+		v, _ := m.At(10)
+		m.Destroy()
+		if released != 900 {
+			t.Errorf("%d records were released while a version is still held, want 900", released)
+		}
+		v.Destroy()
+		tr.AssertReleased()
+	})
+}
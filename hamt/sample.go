@@ -0,0 +1,62 @@
+package hamt
+
+import "math/rand"
+
+// RandomEntry returns an entry of the map chosen uniformly at random with r, or false if the map is empty.
+// Every node knows the number of records in its sub-trie, so the entry is found in a single walk down the trie.
+func (m *PersistentHAMT[K, V]) RandomEntry(r *rand.Rand) (k K, v V, ok bool) {
+	m.thaw()
+	if m.root == nil || m.root.size == 0 {
+		return
+	}
+
+	rec := m.root.entryAt(r.Intn(m.root.size))
+	return rec.key, rec.value, true
+}
+
+// Sample returns n distinct entries of the map chosen uniformly at random with r, in no particular order, or every
+// entry of the map if it holds n entries or less. It costs O(n log(Len())).
+func (m *PersistentHAMT[K, V]) Sample(r *rand.Rand, n int) []Entry[K, V] {
+	size := m.Len()
+	if n > size {
+		n = size
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	// Floyd's algorithm picks n distinct indexes out of size
+	picked := make(map[int]struct{}, n)
+	entries := make([]Entry[K, V], 0, n)
+	for j := size - n; j < size; j++ {
+		i := r.Intn(j + 1)
+		if _, ok := picked[i]; ok {
+			i = j
+		}
+		picked[i] = struct{}{}
+
+		rec := m.root.entryAt(i)
+		entries = append(entries, Entry[K, V]{Key: rec.key, Value: rec.value})
+	}
+
+	return entries
+}
+
+// entryAt returns the i-th entry of the sub-trie under n, counting the entries of the node before the entries of
+// its sub-nodes.
+func (n *trieNode[K, E]) entryAt(i int) E {
+	for {
+		if i < len(n.entries) {
+			return n.entries[i]
+		}
+
+		i -= len(n.entries)
+		for _, child := range n.nodes {
+			if i < child.size {
+				n = child
+				break
+			}
+			i -= child.size
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package hamt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRandomEntry(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	m := NewPersistentHAMT[int, int](newIntHasher())
+	for i := 0; i < 2000; i++ {
+		m.Set(i, i)
+	}
+	clone := m.Clone()
+	for i := 0; i < 2000; i += 2 {
+		clone.Delete(i)
+	}
+	clone.Update(1, func(old int, ok bool) (int, bool) { return -1, true })
+
+	const draws = 200000
+	counts := make(map[int]int)
+	for i := 0; i < draws; i++ {
+		k, v, ok := clone.RandomEntry(r)
+		if !ok || k%2 != 1 || (v != k && k != 1) {
+			t.Fatalf("RandomEntry() = %d, %d, %v", k, v, ok)
+		}
+		counts[k]++
+	}
+
+	// every entry is drawn about 200 times
+	if len(counts) != 1000 {
+		t.Fatalf("RandomEntry() drew %d entries, want 1000", len(counts))
+	}
+	for k, n := range counts {
+		if n < 100 || n > 300 {
+			t.Errorf("RandomEntry() drew %d %d times, want about %d", k, n, draws/1000)
+		}
+	}
+
+	for _, empty := range []*PersistentHAMT[int, int]{NewPersistentHAMT[int, int](newIntHasher()), {}} {
+		if _, _, ok := empty.RandomEntry(r); ok {
+			t.Errorf("RandomEntry() on an empty map should return false")
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	m := NewPersistentHAMT[int, int](newIntHasher())
+	for i := 0; i < 100; i++ {
+		m.Set(i, i*2)
+	}
+
+	counts := make(map[int]int)
+	for i := 0; i < 10000; i++ {
+		entries := m.Sample(r, 10)
+		if len(entries) != 10 {
+			t.Fatalf("Sample(10) returned %d entries", len(entries))
+		}
+
+		seen := make(map[int]bool)
+		for _, e := range entries {
+			if seen[e.Key] || e.Value != e.Key*2 {
+				t.Fatalf("Sample(10) = %v", entries)
+			}
+			seen[e.Key] = true
+			counts[e.Key]++
+		}
+	}
+
+	// every entry is sampled about 1000 times
+	for k := 0; k < 100; k++ {
+		if n := counts[k]; n < 800 || n > 1200 {
+			t.Errorf("Sample() picked %d %d times, want about 1000", k, n)
+		}
+	}
+
+	if entries := m.Sample(r, 1000); len(entries) != 100 {
+		t.Errorf("Sample(1000) returned %d entries, want all 100", len(entries))
+	}
+	if entries := m.Sample(r, 0); len(entries) != 0 {
+		t.Errorf("Sample(0) = %v", entries)
+	}
+}
+
+func TestNodeSize(t *testing.T) {
+	a := NewPersistentHAMT[int, int](newIntHasher())
+	b := NewPersistentHAMT[int, int](newIntHasher())
+	src := make(map[int]int)
+	for i := 0; i < 3000; i++ {
+		a.Set(i, i)
+		b.Set(i+1500, i)
+		src[i] = i
+	}
+
+	c := NewCtrie[int, int](newIntHasher())
+	for i := 0; i < 3000; i++ {
+		c.Store(i, i)
+	}
+
+	check := func(name string, m *PersistentHAMT[int, int]) {
+		t.Helper()
+		validateNode(t, m.root)
+		if size := m.root.size; size != m.Len() {
+			t.Errorf("%s: root size = %d, Len() = %d", name, size, m.Len())
+		}
+	}
+
+	check("Merge", Merge(a, b, nil))
+	check("Intersect", Intersect(a, b, func(k, va, vb int) int { return va + vb }))
+	check("Subtract", Subtract(a, b))
+	check("Filter", Filter(a, func(k, v int) bool { return k%3 == 0 }))
+	check("MapValues", MapValues[int, int, int](a, func(k, v int) int { return -v }, newIntHasher()))
+	check("FromMap", FromMap[int, int](newIntHasher(), src))
+	check("ToPersistentHAMT", c.ToPersistentHAMT())
+
+	clone := a.Clone()
+	for i := 0; i < 3000; i += 3 {
+		clone.GetOrInsert(i+3000, func() int { return i })
+		clone.Update(i, func(old int, ok bool) (int, bool) { return old, false })
+	}
+	check("Update", clone)
+	check("original", a)
+}
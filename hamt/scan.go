@@ -0,0 +1,112 @@
+package hamt
+
+import "math/bits"
+
+// Scan returns up to count entries of the map starting at cursor, along with the cursor of the next call. Start with
+// a cursor of 0 and call Scan again with the returned cursor until it returns 0. If count is not positive, Scan
+// returns up to 10 entries per call.
+// The cursor is a position on the hash paths of the trie: entries are returned in the order of the paths of their
+// keys, which only depend on the keys, not on the other entries of the map. So a scan over a single version returns
+// every key exactly once, and a scan over a map that is changed between calls returns every key that exists
+// throughout the scan exactly once; keys added or deleted during the scan may or may not be returned.
+// Keys whose hashes fully collide share the same path and are returned by the same call, so a call can return more
+// than count entries.
+func (m *PersistentHAMT[K, V]) Scan(cursor uint64, count int) (entries []Entry[K, V], next uint64) {
+	if count <= 0 {
+		count = 10
+	}
+
+	m.thaw()
+	if m.root == nil {
+		return nil, 0
+	}
+
+	s := scanner[K, V]{m: m, cursor: cursor, count: count}
+	s.scan(m.root, 0, 0)
+	return s.entries, s.next
+}
+
+// pathShift returns the position of the bucket of the given depth in a path. The buckets of the hash levels are
+// stored from the most significant bits of the path down, so the paths sort in the order of the trie traversal.
+// Paths only cover the first hash: the levels of the rehashes are only reached by keys with the same first hash,
+// hence the same path.
+func pathShift(depth int) int {
+	if depth == exhaustedLevel {
+		return 0
+	}
+	return 64 - arity*(depth+1)
+}
+
+// hashPath returns the path of the hash, the buckets of all its levels in the order of the trie traversal.
+func hashPath(hash uint64) uint64 {
+	var path uint64
+	for depth := 0; depth <= exhaustedLevel; depth++ {
+		path |= uint64(bucket(hash, depth*arity)) << pathShift(depth)
+	}
+	return path
+}
+
+type scanner[K comparable, V any] struct {
+	m       *PersistentHAMT[K, V]
+	cursor  uint64
+	count   int
+	entries []Entry[K, V]
+	next    uint64
+}
+
+// add adds the entries of a path to the result, unless the result is full, in which case the path is the cursor
+// of the next call. It reports whether the scan is over.
+func (s *scanner[K, V]) add(path uint64, f func(add func(k K, v V) bool) bool) bool {
+	if len(s.entries) >= s.count {
+		s.next = path
+		return true
+	}
+
+	f(func(k K, v V) bool {
+		s.entries = append(s.entries, Entry[K, V]{Key: k, Value: v})
+		return false
+	})
+	return false
+}
+
+// scan visits the entries of the node n at the given depth with paths starting with prefix, from the cursor on.
+// It reports whether the scan is over.
+func (s *scanner[K, V]) scan(n *trieNode[K, *record[K, V]], depth int, prefix uint64) bool {
+	shift := pathShift(depth)
+
+	for slots := n.dataMap | n.nodeMap; slots != 0; slots &= slots - 1 {
+		slot := bits.TrailingZeros64(slots)
+
+		// the range of the paths under the slot
+		low := prefix | uint64(slot)<<shift
+		high := low | (1<<shift - 1)
+		if high < s.cursor {
+			continue
+		}
+
+		r, child := n.TryGetBlock(slot)
+		switch {
+		case r != nil:
+			path := hashPath(s.m.hash(r.key, 0))
+			if path < s.cursor {
+				continue
+			}
+			if s.add(path, func(add func(k K, v V) bool) bool { return add(r.key, r.value) }) {
+				return true
+			}
+
+		case depth == exhaustedLevel:
+			// the keys under the child have the same first hash
+			if s.add(low, func(add func(k K, v V) bool) bool { return s.m._range(child, add) }) {
+				return true
+			}
+
+		default:
+			if s.scan(child, depth+1, low) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,125 @@
+package hamt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// scanAll scans m from the start, calling between after every call, and returns how many times every key was
+// returned.
+func scanAll(t *testing.T, m *PersistentHAMT[int, int], count int, between func()) map[int]int {
+	t.Helper()
+
+	seen := make(map[int]int)
+	var cursor uint64
+	for calls := 0; ; calls++ {
+		if calls > m.Len()+10 {
+			t.Fatalf("Scan() didn't end after %d calls", calls)
+		}
+
+		entries, next := m.Scan(cursor, count)
+		for _, e := range entries {
+			seen[e.Key]++
+		}
+		if next == 0 {
+			return seen
+		}
+		if next <= cursor {
+			t.Fatalf("Scan(%x) returned the cursor %x", cursor, next)
+		}
+		cursor = next
+		between()
+	}
+}
+
+func TestScan(t *testing.T) {
+	const size = 10000
+
+	m := NewPersistentHAMT[int, int](newIntHasher())
+	for i := 0; i < size; i++ {
+		m.Set(i, i)
+	}
+
+	t.Run("Version", func(t *testing.T) {
+		for _, count := range []int{0, 1, 7, 100, size * 2} {
+			calls := 0
+			seen := scanAll(t, m, count, func() { calls++ })
+
+			if len(seen) != size {
+				t.Fatalf("Scan(count %d) returned %d keys, want %d", count, len(seen), size)
+			}
+			for k, n := range seen {
+				if n != 1 {
+					t.Fatalf("Scan(count %d) returned %d %d times", count, k, n)
+				}
+			}
+			if count > 0 && calls != (size-1)/count {
+				t.Errorf("Scan(count %d) took %d calls", count, calls+1)
+			}
+		}
+	})
+
+	t.Run("Mutated", func(t *testing.T) {
+		// keys below size exist throughout the scan, the others come and go
+		clone := m.Clone()
+		seen := scanAll(t, clone, 50, func() {
+			for i := 0; i < 20; i++ {
+				k := size + rand.Intn(size)
+				if rand.Intn(2) == 0 {
+					clone.Set(k, k)
+				} else {
+					clone.Delete(k)
+				}
+				clone.Set(rand.Intn(size), -1)
+			}
+		})
+
+		for k := 0; k < size; k++ {
+			if seen[k] != 1 {
+				t.Fatalf("Scan() returned %d %d times", k, seen[k])
+			}
+		}
+		for k, n := range seen {
+			if n != 1 {
+				t.Fatalf("Scan() returned %d %d times", k, n)
+			}
+		}
+	})
+
+	t.Run("Collisions", func(t *testing.T) {
+		// the keys with the same last digit have the same hash, hence the same path
+		c := NewPersistentHAMT[int, int](levelHasher{})
+		for i := 0; i < 1000; i++ {
+			c.Set(i, i)
+		}
+
+		calls := 0
+		seen := scanAll(t, c, 1, func() { calls++ })
+		if len(seen) != 1000 {
+			t.Fatalf("Scan() returned %d keys, want 1000", len(seen))
+		}
+		if calls != 9 {
+			t.Errorf("Scan() took %d calls, want one per hash", calls+1)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		for _, empty := range []*PersistentHAMT[int, int]{NewPersistentHAMT[int, int](newIntHasher()), {}} {
+			if entries, next := empty.Scan(0, 10); len(entries) != 0 || next != 0 {
+				t.Errorf("Scan() on an empty map = %v, %d", entries, next)
+			}
+		}
+	})
+}
+
+func TestHashPath(t *testing.T) {
+	// paths sort in the order of the trie traversal: by the bucket of the first level, then the second level...
+	hashes := []uint64{0, 1, 1 << 6, 2, 1 << 63, 63, 1<<6 | 63}
+	want := []uint64{0, 1 << 58, 1 << 52, 2 << 58, 8, 1<<64 - 1<<58, 1<<64 - 1<<58 | 1<<52}
+
+	for i, h := range hashes {
+		if got := hashPath(h); got != want[i] {
+			t.Errorf("hashPath(%x) = %x, want %x", h, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package hamt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+	"sync/atomic"
+)
+
+// Stats describes the shape of the trie of a map. Depths start at 0 for the root node.
+type Stats struct {
+	Nodes   int // number of nodes, including the root
+	Records int // number of records, i.e. the length of the map
+
+	// NodesPerDepth[d] is the number of nodes at depth d. Its length is the height of the trie.
+	NodesPerDepth []int
+
+	// RecordsPerDepth[d] is the number of records stored in the nodes at depth d. With a hasher that spreads keys
+	// well, almost all records are found in the first few levels.
+	RecordsPerDepth []int
+
+	// RehashLevels is the number of times the hash of a key had to be recomputed with Rehash because the bits of the
+	// previous hash were exhausted. It is 0 unless some keys collide on all the bits of their hash.
+	RehashLevels int
+
+	// AvgPopulation is the average number of used slots (records and sub-nodes) per node, out of 64.
+	AvgPopulation float64
+
+	// SharedNodes is the number of nodes also referenced by another version of the map (refCount > 1), and
+	// SharedRecords the number of records found under them. Everything under a shared node is shared as well.
+	SharedNodes   int
+	SharedRecords int
+}
+
+// Stats walks the trie and returns statistics about its shape.
+func (m *PersistentHAMT[K, V]) Stats() Stats {
+	m.thaw()
+	var s Stats
+	if m.root == nil || m.root.isEmpty() {
+		return s
+	}
+
+	population := 0
+	var walk func(n *trieNode[K, *record[K, V]], depth int, shared bool)
+	walk = func(n *trieNode[K, *record[K, V]], depth int, shared bool) {
+		if len(s.NodesPerDepth) == depth {
+			s.NodesPerDepth = append(s.NodesPerDepth, 0)
+			s.RecordsPerDepth = append(s.RecordsPerDepth, 0)
+		}
+
+		if atomic.LoadInt32(&n.refCount) > 1 {
+			s.SharedNodes++
+			shared = true
+		}
+		if shared {
+			s.SharedRecords += len(n.entries)
+		}
+
+		s.Nodes++
+		s.Records += len(n.entries)
+		s.NodesPerDepth[depth]++
+		s.RecordsPerDepth[depth] += len(n.entries)
+		population += bits.OnesCount64(n.dataMap | n.nodeMap)
+
+		for _, child := range n.nodes {
+			walk(child, depth+1, shared)
+		}
+	}
+	walk(m.root, 0, false)
+
+	// the deepest node uses the hash of its depth, see hashAt
+	s.RehashLevels = (len(s.NodesPerDepth) - 1) / (exhaustedLevel + 1)
+	s.AvgPopulation = float64(population) / float64(s.Nodes)
+	return s
+}
+
+// String formats the statistics on several lines.
+func (s Stats) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "nodes: %d, records: %d, rehash levels: %d\n", s.Nodes, s.Records, s.RehashLevels)
+	fmt.Fprintf(&sb, "average population: %.2f/%d\n", s.AvgPopulation, childPerNode)
+	fmt.Fprintf(&sb, "shared nodes: %d, shared records: %d\n", s.SharedNodes, s.SharedRecords)
+	for depth, nodes := range s.NodesPerDepth {
+		fmt.Fprintf(&sb, "depth %d: %d nodes, %d records\n", depth, nodes, s.RecordsPerDepth[depth])
+	}
+	return sb.String()
+}
+
+// Dump writes a text representation of the trie to w, one line per node or record, indented by depth.
+// Every line starts with the slot of the entry in its parent. Nodes show their reference count.
+func (m *PersistentHAMT[K, V]) Dump(w io.Writer) error {
+	m.thaw()
+	bw := bufio.NewWriter(w)
+
+	var dump func(n *trieNode[K, *record[K, V]], depth int)
+	dump = func(n *trieNode[K, *record[K, V]], depth int) {
+		indent := strings.Repeat("  ", depth+1)
+
+		for used := n.dataMap | n.nodeMap; used != 0; used &= used - 1 {
+			loc := bits.TrailingZeros64(used)
+			r, child := n.TryGetBlock(loc)
+
+			if r != nil {
+				fmt.Fprintf(bw, "%s[%d] %v: %v\n", indent, loc, r.key, r.value)
+				continue
+			}
+
+			fmt.Fprintf(bw, "%s[%d] node refs=%d\n", indent, loc, atomic.LoadInt32(&child.refCount))
+			dump(child, depth+1)
+		}
+	}
+
+	root := nodeOrEmpty(m.root)
+	fmt.Fprintf(bw, "root refs=%d len=%d\n", atomic.LoadInt32(&root.refCount), m.len)
+	dump(root, 0)
+
+	return bw.Flush()
+}
+
+// WriteDOT writes the trie to w in the DOT language of Graphviz. Nodes are drawn as boxes labeled with their
+// reference count, records as ellipses labeled with their key, and edges are labeled with the slot of the child.
+func (m *PersistentHAMT[K, V]) WriteDOT(w io.Writer) error {
+	m.thaw()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph hamt {")
+
+	nodes, records := 0, 0
+
+	var dot func(n *trieNode[K, *record[K, V]]) int
+	dot = func(n *trieNode[K, *record[K, V]]) int {
+		id := nodes
+		nodes++
+		fmt.Fprintf(bw, "  n%d [shape=box, label=\"refs=%d\"];\n", id, atomic.LoadInt32(&n.refCount))
+
+		for used := n.dataMap | n.nodeMap; used != 0; used &= used - 1 {
+			loc := bits.TrailingZeros64(used)
+			r, child := n.TryGetBlock(loc)
+
+			if r != nil {
+				fmt.Fprintf(bw, "  r%d [label=%q];\n", records, fmt.Sprint(r.key))
+				fmt.Fprintf(bw, "  n%d -> r%d [label=\"%d\"];\n", id, records, loc)
+				records++
+				continue
+			}
+
+			fmt.Fprintf(bw, "  n%d -> n%d [label=\"%d\"];\n", id, dot(child), loc)
+		}
+
+		return id
+	}
+	dot(nodeOrEmpty(m.root))
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
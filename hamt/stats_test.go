@@ -0,0 +1,102 @@
+package hamt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		var zero PersistentHAMT[int, int]
+		if s := zero.Stats(); s.Nodes != 0 || s.Records != 0 || len(s.NodesPerDepth) != 0 {
+			t.Errorf("Stats() of an empty map = %+v", s)
+		}
+		if s := NewPersistentHAMT[int, int](newIntHasher()).Stats(); s.Nodes != 0 {
+			t.Errorf("Stats() of an empty map = %+v", s)
+		}
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		src := make(map[int]int)
+		for i := 0; i < 10000; i++ {
+			src[i*13] = i
+		}
+		m := buildMap(src)
+		s := m.Stats()
+
+		nodes, records := 0, 0
+		dfsRef(t, m.root, func(n *trieNode[int, *record[int, int]]) bool {
+			nodes++
+			records += len(n.entries)
+			return false
+		})
+
+		if s.Nodes != nodes || s.Records != len(src) || records != len(src) {
+			t.Errorf("Stats() = %d nodes, %d records, want %d nodes, %d records", s.Nodes, s.Records, nodes, len(src))
+		}
+
+		sumNodes, sumRecords := 0, 0
+		for depth := range s.NodesPerDepth {
+			sumNodes += s.NodesPerDepth[depth]
+			sumRecords += s.RecordsPerDepth[depth]
+		}
+		if sumNodes != s.Nodes || sumRecords != s.Records || s.NodesPerDepth[0] != 1 {
+			t.Errorf("the histograms don't add up: %v", s)
+		}
+
+		if s.RehashLevels != 0 || s.SharedNodes != 0 || s.AvgPopulation <= 1 || s.AvgPopulation > childPerNode {
+			t.Errorf("unexpected Stats() = %v", s)
+		}
+
+		clone := m.Clone()
+		clone.Set(-1, -1)
+		s = m.Stats()
+		if s.SharedNodes == 0 || s.SharedRecords == 0 || s.SharedRecords >= s.Records {
+			t.Errorf("after Clone and Set, some but not all records should be shared: %v", s)
+		}
+	})
+
+	t.Run("Rehash", func(t *testing.T) {
+		m := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		for i, k := range []string{"a", "rehash2time_1", "rehash2time_2"} {
+			m.Set(k, i)
+		}
+
+		if s := m.Stats(); s.RehashLevels != 2 || s.Records != 3 {
+			t.Errorf("Stats() = %v, want 2 rehash levels", s)
+		}
+	})
+}
+
+func TestDump(t *testing.T) {
+	m := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+	for i, k := range []string{"a", "b", "fullhash_1", "fullhash_2"} {
+		m.Set(k, i)
+	}
+
+	var buf bytes.Buffer
+	if err := m.Dump(&buf); err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "root refs=1 len=4\n") || !strings.Contains(out, "node refs=1") {
+		t.Errorf("unexpected Dump() output:\n%s", out)
+	}
+	for _, k := range []string{"a", "b", "fullhash_1", "fullhash_2"} {
+		if !strings.Contains(out, "] "+k+": ") {
+			t.Errorf("Dump() output should list the key %q:\n%s", k, out)
+		}
+	}
+
+	buf.Reset()
+	if err := m.WriteDOT(&buf); err != nil {
+		t.Fatalf("WriteDOT() error = %v", err)
+	}
+	out = buf.String()
+	s := m.Stats()
+	if !strings.HasPrefix(out, "digraph hamt {\n") || !strings.HasSuffix(out, "}\n") ||
+		strings.Count(out, "shape=box") != s.Nodes || strings.Count(out, " -> ") != s.Nodes-1+s.Records {
+		t.Errorf("unexpected WriteDOT() output:\n%s", out)
+	}
+}
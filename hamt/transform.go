@@ -0,0 +1,88 @@
+package hamt
+
+import "math/bits"
+
+// Filter returns a new map holding the entries of m for which pred returns true.
+// A sub-node whose entries all pass is shared with the result instead of being copied, so filtering out a few
+// entries only copies the paths leading to them.
+func Filter[K comparable, V any](m *PersistentHAMT[K, V], pred func(k K, v V) bool) *PersistentHAMT[K, V] {
+	m.thaw()
+	result := &PersistentHAMT[K, V]{}
+	result.hasher = m.hasher
+
+	if m.root != nil {
+		result.root = filterNode(m.root, pred, &result.len, true)
+	}
+
+	return result
+}
+
+// filterNode keeps the records under n for which pred returns true. kept is increased by the number of records kept.
+// The returned node is nil if no record is kept.
+func filterNode[K comparable, V any](n *trieNode[K, *record[K, V]], pred func(k K, v V) bool, kept *int, isRoot bool) *trieNode[K, *record[K, V]] {
+	n1 := newMapNodeWithRef[K, V]()
+
+	for used := n.dataMap | n.nodeMap; used != 0; used &= used - 1 {
+		var mask uint64 = 1 << bits.TrailingZeros64(used)
+
+		if n.dataMap&mask != 0 {
+			if r := n.entries[n.entryIndex(mask)]; pred(r.key, r.value) {
+				*kept++
+				n1.appendEntry(mask, r.incRef())
+			}
+			continue
+		}
+
+		n1.appendNode(mask, filterNode(n.nodes[n.nodeIndex(mask)], pred, kept, false))
+	}
+
+	if n1.isEmpty() && !isRoot {
+		n1.decRef()
+		return nil
+	}
+
+	return reuse(n1, n)
+}
+
+// MapValues returns a new map holding the keys of m with their values replaced by f(k, v).
+// Keys and their hashes don't change, so the result is built with the same bitmaps as m without hashing any key.
+// The hasher h is used by later operations on the result; it must hash the keys the same way as the hasher of m.
+func MapValues[K comparable, V any, W any](m *PersistentHAMT[K, V], f func(k K, v V) W, h Hasher[K]) *PersistentHAMT[K, W] {
+	m.thaw()
+	result := &PersistentHAMT[K, W]{}
+	result.hasher, result.len = h, m.len
+
+	if m.root != nil {
+		result.root = mapNodeValues(m.root, f)
+	}
+
+	return result
+}
+
+func mapNodeValues[K comparable, V any, W any](n *trieNode[K, *record[K, V]], f func(k K, v V) W) *trieNode[K, *record[K, W]] {
+	n1 := newMapNodeWithRef[K, W]()
+	n1.dataMap, n1.nodeMap, n1.size = n.dataMap, n.nodeMap, n.size
+	n1.entries = make([]*record[K, W], len(n.entries))
+	n1.nodes = make([]*trieNode[K, *record[K, W]], len(n.nodes))
+
+	for i, r := range n.entries {
+		n1.entries[i] = newRecord[K, W](r.key, f(r.key, r.value), nil)
+	}
+
+	for i, child := range n.nodes {
+		n1.nodes[i] = mapNodeValues(child, f)
+	}
+
+	return n1
+}
+
+// Fold calls f for every entry of m, in no particular order, threading an accumulator through the calls.
+// It returns the accumulator returned by the last call, or init if m is empty.
+func Fold[K comparable, V any, A any](m *PersistentHAMT[K, V], init A, f func(acc A, k K, v V) A) A {
+	acc := init
+	m.Range(func(k K, v V) bool {
+		acc = f(acc, k, v)
+		return false
+	})
+	return acc
+}
@@ -0,0 +1,108 @@
+package hamt
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	m := NewPersistentHAMT[int, int](newIntHasher())
+	expected := make(map[int]int)
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+		if i%3 != 0 {
+			expected[i] = i
+		}
+	}
+
+	filtered := Filter(m, func(k int, v int) bool { return v%3 != 0 })
+	validateResult(t, filtered, expected)
+
+	if all := Filter(m, func(k int, v int) bool { return true }); all.root != m.root || all.Len() != m.Len() {
+		t.Errorf("a filter keeping every entry should reuse the root")
+	}
+
+	none := Filter(m, func(k int, v int) bool { return false })
+	if none.Len() != 0 || len(none.ToMap()) != 0 {
+		t.Errorf("a filter keeping no entry should return an empty map")
+	}
+	none.Set(1, 1)
+	if v, ok := none.Get(1); !ok || v != 1 {
+		t.Errorf("none.Get(1) = %d, want %d", v, 1)
+	}
+
+	// dropping a single key only copies the path to it
+	one := Filter(m, func(k int, v int) bool { return k != 42 })
+	shared := 0
+	for i, child := range one.root.nodes {
+		if child == m.root.nodes[i] {
+			shared++
+		}
+	}
+	if shared != len(m.root.nodes)-1 {
+		t.Errorf("untouched sub-tries should be shared, shared %d of %d", shared, len(m.root.nodes))
+	}
+
+	t.Run("Collision", func(t *testing.T) {
+		m := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		keys := []string{"a", "b", "c", "d", "e", "rehash2time_1", "rehash2time_2"}
+		for i, k := range keys {
+			m.Set(k, i)
+		}
+
+		filtered := Filter(m, func(k string, v int) bool { return k != "d" && k != "rehash2time_2" })
+		expected := NewPersistentHAMT[string, int](newCollisionHasher[string]())
+		for i, k := range keys {
+			if k != "d" && k != "rehash2time_2" {
+				expected.Set(k, i)
+			}
+		}
+
+		if !Equal(filtered, expected, intEq) || !sameShape(t, filtered.root, expected.root) {
+			t.Errorf("Filter() = %v, want %v", filtered.ToMap(), expected.ToMap())
+		}
+	})
+}
+
+func TestMapValues(t *testing.T) {
+	m := NewPersistentHAMT[int, int](newIntHasher())
+	for i := 0; i < 5000; i++ {
+		m.Set(i, i)
+	}
+
+	strs := MapValues[int, int, string](m, func(k int, v int) string { return fmt.Sprint(v * 2) }, newIntHasher())
+	if strs.Len() != m.Len() {
+		t.Errorf("strs.Len() = %d, want %d", strs.Len(), m.Len())
+	}
+
+	for i := 0; i < 5000; i++ {
+		if v, ok := strs.Get(i); !ok || v != fmt.Sprint(i*2) {
+			t.Fatalf("strs.Get(%d) = %s, want %d", i, v, i*2)
+		}
+	}
+
+	strs.Set(5000, "new")
+	strs.Delete(0)
+	if strs.Len() != 5000 {
+		t.Errorf("strs.Len() = %d, want %d", strs.Len(), 5000)
+	}
+	if _, ok := m.Get(0); !ok {
+		t.Errorf("MapValues should not change the source map")
+	}
+}
+
+func TestFold(t *testing.T) {
+	m := NewPersistentHAMT[int, int](newIntHasher())
+	for i := 1; i <= 100; i++ {
+		m.Set(i, i)
+	}
+
+	if sum := Fold(m, 0, func(acc int, k int, v int) int { return acc + v }); sum != 5050 {
+		t.Errorf("Fold() = %d, want %d", sum, 5050)
+	}
+
+	empty := NewPersistentHAMT[int, int](newIntHasher())
+	if s := Fold(empty, "init", func(acc string, k int, v int) string { return "" }); s != "init" {
+		t.Errorf("Fold() on an empty map = %s, want init", s)
+	}
+}
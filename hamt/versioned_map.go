@@ -0,0 +1,145 @@
+package hamt
+
+// VersionedMap is a PersistentHAMT with a bounded history of committed versions.
+// Changes are made to a working copy, and Commit saves a clone of it under a new version number. Since clones share
+// their nodes, every committed version only costs the paths changed since the previous one. When the history is
+// full, the oldest version is destroyed, which releases the records no other version holds.
+type VersionedMap[K comparable, V any] struct {
+	working *PersistentHAMT[K, V]
+	history []committedVersion[K, V] // oldest first
+	limit   int
+	last    uint64 // the number of the last committed version, 0 if none
+}
+
+type committedVersion[K comparable, V any] struct {
+	version uint64
+	m       *PersistentHAMT[K, V]
+}
+
+// NewVersionedMap returns an empty VersionedMap using h to hash the keys, keeping the last limit committed versions,
+// or all of them if limit is not positive.
+func NewVersionedMap[K comparable, V any](h Hasher[K], limit int) *VersionedMap[K, V] {
+	return &VersionedMap[K, V]{
+		working: NewPersistentHAMT[K, V](h),
+		limit:   limit,
+	}
+}
+
+// Len returns the number of entries of the working copy.
+func (m *VersionedMap[K, V]) Len() int {
+	return m.working.Len()
+}
+
+// Get returns the value of the key k in the working copy and whether it was found.
+func (m *VersionedMap[K, V]) Get(k K) (V, bool) {
+	return m.working.Get(k)
+}
+
+// Set sets the value of the key k in the working copy.
+func (m *VersionedMap[K, V]) Set(k K, v V) {
+	m.working.Set(k, v)
+}
+
+// Put is like Set, with release called once no version holds the entry anymore, see PersistentHAMT.Put.
+func (m *VersionedMap[K, V]) Put(k K, v V, release func(key K, value V)) {
+	m.working.Put(k, v, release)
+}
+
+// Delete removes the key k from the working copy and reports whether it was found.
+func (m *VersionedMap[K, V]) Delete(k K) bool {
+	return m.working.Delete(k)
+}
+
+// Range calls f for every entry of the working copy, in no particular order, until f returns true.
+func (m *VersionedMap[K, V]) Range(f func(k K, v V) bool) {
+	m.working.Range(f)
+}
+
+// Commit saves the working copy as a new version and returns its number. Versions are numbered from 1 in commit
+// order. If the history then holds more than its limit, the oldest versions are destroyed.
+func (m *VersionedMap[K, V]) Commit() uint64 {
+	m.last++
+	m.history = append(m.history, committedVersion[K, V]{version: m.last, m: m.working.Clone()})
+
+	if m.limit > 0 && len(m.history) > m.limit {
+		evicted := len(m.history) - m.limit
+		for i := 0; i < evicted; i++ {
+			m.history[i].m.Destroy()
+			m.history[i] = committedVersion[K, V]{}
+		}
+		m.history = m.history[evicted:]
+	}
+
+	return m.last
+}
+
+// Versions returns the numbers of the versions in the history, oldest first.
+func (m *VersionedMap[K, V]) Versions() []uint64 {
+	versions := make([]uint64, len(m.history))
+	for i, c := range m.history {
+		versions[i] = c.version
+	}
+	return versions
+}
+
+// find returns the committed version, or nil if it is not in the history.
+func (m *VersionedMap[K, V]) find(version uint64) *PersistentHAMT[K, V] {
+	if len(m.history) == 0 || version < m.history[0].version {
+		return nil
+	}
+
+	// the versions of the history are consecutive
+	if i := version - m.history[0].version; i < uint64(len(m.history)) {
+		return m.history[i].m
+	}
+	return nil
+}
+
+// At returns a clone of the committed version, or false if it is not in the history. The clone should be destroyed
+// once no longer used, so the records of the version can be released once it is evicted.
+func (m *VersionedMap[K, V]) At(version uint64) (*PersistentHAMT[K, V], bool) {
+	if v := m.find(version); v != nil {
+		return v.Clone(), true
+	}
+	return nil, false
+}
+
+// Rollback replaces the working copy with the committed version, dropping the uncommitted changes, and reports
+// whether the version is in the history. The history is left untouched: the versions committed after it can still be
+// read, and the next commit gets a new number.
+func (m *VersionedMap[K, V]) Rollback(version uint64) bool {
+	v := m.find(version)
+	if v == nil {
+		return false
+	}
+
+	m.working.Destroy()
+	m.working = v.Clone()
+	return true
+}
+
+// DiffSince returns the changes from the committed version to the working copy, or false if the version is not in
+// the history. A key is reported as Changed when eq reports false for its two values.
+// Like Diff, DiffSince only visits the parts of the trie that changed since the version.
+func (m *VersionedMap[K, V]) DiffSince(version uint64, eq func(V, V) bool) ([]Change[K, V], bool) {
+	v := m.find(version)
+	if v == nil {
+		return nil, false
+	}
+
+	var changes []Change[K, V]
+	Diff(v, m.working, eq, func(c Change[K, V]) bool {
+		changes = append(changes, c)
+		return false
+	})
+	return changes, true
+}
+
+// Destroy releases the working copy and every version of the history.
+func (m *VersionedMap[K, V]) Destroy() {
+	m.working.Destroy()
+	for _, c := range m.history {
+		c.m.Destroy()
+	}
+	m.history = nil
+}
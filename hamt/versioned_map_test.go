@@ -0,0 +1,94 @@
+package hamt
+
+import (
+	"math/rand"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestVersionedMap(t *testing.T) {
+	t.Run("History", func(t *testing.T) {
+		const limit = 5
+
+		m := NewVersionedMap[int, int](newIntHasher(), limit)
+		expected := make(map[int]int)
+		snapshots := make(map[uint64]map[int]int)
+
+		for i := 0; i < 3000; i++ {
+			k := rand.Intn(200)
+			if rand.Intn(3) == 0 {
+				m.Delete(k)
+				delete(expected, k)
+			} else {
+				m.Set(k, i)
+				expected[k] = i
+			}
+
+			if i%100 != 99 {
+				continue
+			}
+
+			version := m.Commit()
+			snapshots[version] = make(map[int]int, len(expected))
+			for k, v := range expected {
+				snapshots[version][k] = v
+			}
+
+			var want []uint64
+			for v := version; v > 0 && v+limit > version; v-- {
+				want = append([]uint64{v}, want...)
+			}
+			if got := m.Versions(); !slices.Equal(got, want) {
+				t.Fatalf("Versions() = %v, want %v", got, want)
+			}
+		}
+
+		last := m.Versions()[limit-1]
+		for version, snapshot := range snapshots {
+			v, ok := m.At(version)
+			if ok != (version > last-limit) {
+				t.Fatalf("At(%d) found = %v with the last version %d", version, ok, last)
+			}
+			if ok {
+				assertSameMap(t, v.ToMap(), snapshot)
+				v.Destroy()
+			}
+		}
+
+		// uncommitted changes
+		m.Set(1000, 1000)
+		m.Delete(int(last%200) + 1) // may not exist
+		changes, ok := m.DiffSince(last-2, intEq)
+		if !ok {
+			t.Fatalf("DiffSince(%d) should find the version", last-2)
+		}
+		applied := make(map[int]int)
+		for k, v := range snapshots[last-2] {
+			applied[k] = v
+		}
+		for _, c := range changes {
+			if c.Kind == Removed {
+				delete(applied, c.Key)
+			} else {
+				applied[c.Key] = c.New
+			}
+		}
+		assertSameMap(t, applied, m.working.ToMap())
+
+		if _, ok := m.DiffSince(1, intEq); ok {
+			t.Errorf("DiffSince() of an evicted version should not be found")
+		}
+
+		if !m.Rollback(last - 1) {
+			t.Fatalf("Rollback(%d) should find the version", last-1)
+		}
+		assertSameMap(t, m.working.ToMap(), snapshots[last-1])
+		if m.Rollback(last+1) || m.Rollback(0) {
+			t.Errorf("Rollback() to a missing version should fail")
+		}
+		if version := m.Commit(); version != last+1 {
+			t.Errorf("Commit() after Rollback() = %d, want %d", version, last+1)
+		}
+	})
+}
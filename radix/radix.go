@@ -0,0 +1,355 @@
+// Package radix implements PersistentRadixTree, a persistent map from string or []byte keys, sorted by key and
+// supporting prefix queries.
+//
+// The tree is prefix compressed: a run of bytes that no two keys branch on is stored once, as the prefix of a single
+// node, so every node below the root holds a value or has at least two children. Insert splits a prefix where a new key
+// leaves it, and Delete merges a node left without a value and with a single child into that child. Versions share
+// their nodes through reference counts, and a write only copies the nodes of its path that another version or parent
+// still references.
+package radix
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/nnhatnam/immutable/slice"
+)
+
+// Key is the constraint of the keys of a PersistentRadixTree. Keys are compared byte by byte.
+type Key interface {
+	~string | ~[]byte
+}
+
+// node is a node of the tree. The key of the node is the concatenation of the prefixes from the root down to it.
+// The children are sorted by the first byte of their prefix, which is unique among them.
+type node[V any] struct {
+	prefix   string
+	children []*node[V]
+	refCount int32
+
+	hasValue bool
+	key      string // the key of the node, if hasValue is true
+	value    V
+}
+
+func (n *node[V]) incRef() *node[V] {
+	if n != nil {
+		atomic.AddInt32(&n.refCount, 1)
+	}
+	return n
+}
+
+func (n *node[V]) decRef() {
+	if n == nil {
+		return
+	}
+
+	if atomic.AddInt32(&n.refCount, -1) == 0 {
+		for i, child := range n.children {
+			child.decRef()
+			n.children[i] = nil
+		}
+		n.children = nil
+	}
+}
+
+// mutable returns a version of n whose prefix, value and children the caller may change, taking over the reference
+// of the caller to n. That is n itself if the caller holds the only reference; otherwise n is copied, sharing its
+// children, so splitting or merging prefixes under the copy leaves the other versions untouched.
+func (n *node[V]) mutable() *node[V] {
+	if atomic.LoadInt32(&n.refCount) == 1 {
+		return n
+	}
+
+	n1 := &node[V]{
+		prefix:   n.prefix,
+		children: make([]*node[V], len(n.children)),
+		refCount: 1,
+		hasValue: n.hasValue,
+		key:      n.key,
+		value:    n.value,
+	}
+	for i, child := range n.children {
+		n1.children[i] = child.incRef()
+	}
+
+	n.decRef()
+	return n1
+}
+
+// mutableChild replaces the i-th child of the mutable node n with a version it may change, see mutable, and returns
+// it. Insert and Delete call it on every node they descend into, before splitting its prefix or removing its value.
+func (n *node[V]) mutableChild(i int) *node[V] {
+	n.children[i] = n.children[i].mutable()
+	return n.children[i]
+}
+
+// child returns the index of the child of n whose prefix starts with b, and whether there is one. If there is none,
+// the index is where such a child would be inserted.
+func (n *node[V]) child(b byte) (int, bool) {
+	lo, hi := 0, len(n.children)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if n.children[mid].prefix[0] < b {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, lo < len(n.children) && n.children[lo].prefix[0] == b
+}
+
+// PersistentRadixTree is a persistent map from keys to values, sorted by key.
+type PersistentRadixTree[K Key, V any] struct {
+	root *node[V] // the root has an empty prefix; nil if the tree is empty
+	len  int
+}
+
+// NewPersistentRadixTree returns an empty tree.
+func NewPersistentRadixTree[K Key, V any]() *PersistentRadixTree[K, V] {
+	return &PersistentRadixTree[K, V]{}
+}
+
+func (t *PersistentRadixTree[K, V]) Len() int {
+	return t.len
+}
+
+// find returns the node of the key k, or nil if there is none.
+func (t *PersistentRadixTree[K, V]) find(k string) *node[V] {
+	n := t.root
+	for n != nil {
+		if k == "" {
+			if n.hasValue {
+				return n
+			}
+			return nil
+		}
+
+		i, ok := n.child(k[0])
+		if !ok || !strings.HasPrefix(k, n.children[i].prefix) {
+			return nil
+		}
+		n = n.children[i]
+		k = k[len(n.prefix):]
+	}
+	return nil
+}
+
+// Get returns the value of the key k and whether it was found.
+func (t *PersistentRadixTree[K, V]) Get(k K) (_ V, _ bool) {
+	if n := t.find(string(k)); n != nil {
+		return n.value, true
+	}
+	return
+}
+
+// Insert sets the value of the key k to v. It returns the previous value of k and whether k existed.
+func (t *PersistentRadixTree[K, V]) Insert(k K, v V) (old V, replaced bool) {
+	if t.root == nil {
+		t.root = &node[V]{refCount: 1}
+	}
+
+	t.root = t.root.mutable()
+	old, replaced = t.insert(t.root, string(k), string(k), v)
+	if !replaced {
+		t.len++
+	}
+	return
+}
+
+// insert sets the value of key under the mutable node n, where search is the part of key after the key of n.
+func (t *PersistentRadixTree[K, V]) insert(n *node[V], search, key string, v V) (old V, replaced bool) {
+	if search == "" {
+		old, replaced = n.value, n.hasValue
+		n.hasValue, n.key, n.value = true, key, v
+		return
+	}
+
+	i, ok := n.child(search[0])
+	if !ok {
+		n.children = slice.Insert(n.children, i, &node[V]{prefix: search, refCount: 1, hasValue: true, key: key, value: v})
+		return
+	}
+
+	child := n.children[i]
+	common := commonPrefix(search, child.prefix)
+	if common == len(child.prefix) {
+		return t.insert(n.mutableChild(i), search[common:], key, v)
+	}
+
+	// the key leaves the prefix of the child: split the prefix with a new node
+	split := &node[V]{prefix: search[:common], refCount: 1}
+	child = n.children[i].mutable()
+	child.prefix = child.prefix[common:]
+	split.children = []*node[V]{child}
+	n.children[i] = split
+
+	if common == len(search) {
+		split.hasValue, split.key, split.value = true, key, v
+		return
+	}
+
+	leaf := &node[V]{prefix: search[common:], refCount: 1, hasValue: true, key: key, value: v}
+	if leaf.prefix[0] < child.prefix[0] {
+		split.children = []*node[V]{leaf, child}
+	} else {
+		split.children = append(split.children, leaf)
+	}
+	return
+}
+
+// commonPrefix returns the length of the longest common prefix of a and b.
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// Delete removes the key k. It returns the value of k and whether it was found.
+func (t *PersistentRadixTree[K, V]) Delete(k K) (_ V, _ bool) {
+	n := t.find(string(k))
+	if n == nil {
+		return
+	}
+	v := n.value
+
+	t.root = t.root.mutable()
+	t.root = t.delete(t.root, string(k), true)
+	t.len--
+	return v, true
+}
+
+// delete removes the key under the mutable node n, where search is the part of the key after the key of n. The key
+// must exist. It returns the node replacing n in its parent: nil if n is left empty, or its only child, with a
+// longer prefix, if n is left with a single child and no value. The root is never replaced.
+func (t *PersistentRadixTree[K, V]) delete(n *node[V], search string, isRoot bool) *node[V] {
+	if search == "" {
+		var zero V
+		n.hasValue, n.key, n.value = false, "", zero
+	} else {
+		i, _ := n.child(search[0])
+		child := n.mutableChild(i)
+		if child = t.delete(child, search[len(child.prefix):], false); child == nil {
+			n.children = slice.RemoveAt(n.children, i)
+		} else {
+			n.children[i] = child
+		}
+	}
+
+	if isRoot || n.hasValue || len(n.children) > 1 {
+		return n
+	}
+
+	if len(n.children) == 0 {
+		n.decRef()
+		return nil
+	}
+
+	// merge n with its only child
+	child := n.children[0].mutable()
+	child.prefix = n.prefix + child.prefix
+	n.children = nil
+	n.decRef()
+	return child
+}
+
+// LongestPrefix returns the longest key of the tree that is a prefix of k, with its value. ok is false if there is
+// none.
+func (t *PersistentRadixTree[K, V]) LongestPrefix(k K) (key K, v V, ok bool) {
+	var last *node[V]
+	search := string(k)
+
+	for n := t.root; n != nil; {
+		if n.hasValue {
+			last = n
+		}
+		if search == "" {
+			break
+		}
+
+		i, found := n.child(search[0])
+		if !found || !strings.HasPrefix(search, n.children[i].prefix) {
+			break
+		}
+		n = n.children[i]
+		search = search[len(n.prefix):]
+	}
+
+	if last == nil {
+		return
+	}
+	return K(last.key), last.value, true
+}
+
+// WalkPrefix calls f for every key-value pair whose key starts with prefix, in increasing key order, until f returns
+// true. Only the sub-tree of the prefix is visited.
+func (t *PersistentRadixTree[K, V]) WalkPrefix(prefix K, f func(k K, v V) bool) {
+	search := string(prefix)
+
+	for n := t.root; n != nil; {
+		if search == "" {
+			walk(n, f)
+			return
+		}
+
+		i, found := n.child(search[0])
+		if !found {
+			return
+		}
+
+		n = n.children[i]
+		switch {
+		case strings.HasPrefix(search, n.prefix):
+			search = search[len(n.prefix):]
+		case strings.HasPrefix(n.prefix, search):
+			// every key under n starts with the prefix
+			walk(n, f)
+			return
+		default:
+			return
+		}
+	}
+}
+
+// Ascend calls f for every key-value pair in increasing key order, until f returns true.
+func (t *PersistentRadixTree[K, V]) Ascend(f func(k K, v V) bool) {
+	walk(t.root, f)
+}
+
+// walk calls f for every key-value pair under n in increasing key order. It reports whether f returned true.
+func walk[K Key, V any](n *node[V], f func(k K, v V) bool) bool {
+	if n == nil {
+		return false
+	}
+
+	if n.hasValue && f(K(n.key), n.value) {
+		return true
+	}
+
+	for _, child := range n.children {
+		if walk(child, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a copy of the tree in O(1). The two trees share their nodes until they are changed.
+func (t *PersistentRadixTree[K, V]) Clone() *PersistentRadixTree[K, V] {
+	return &PersistentRadixTree[K, V]{
+		root: t.root.incRef(),
+		len:  t.len,
+	}
+}
+
+func (t *PersistentRadixTree[K, V]) Clear() {
+	t.root.decRef()
+	t.root = nil // GC
+	t.len = 0
+}
+
+func (t *PersistentRadixTree[K, V]) Destroy() {
+	t.Clear()
+}
@@ -0,0 +1,287 @@
+package radix
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+// validateNode checks the invariants of the tree under n, whose key is path, and returns its number of values.
+func validateNode[V any](t *testing.T, n *node[V], path string, isRoot bool) int {
+	t.Helper()
+
+	if n.refCount <= 0 {
+		t.Fatalf("node with refCount %d is still referenced", n.refCount)
+	}
+
+	if !isRoot {
+		if n.prefix == "" {
+			t.Fatalf("node under %q has an empty prefix", path)
+		}
+		if !n.hasValue && len(n.children) < 2 {
+			t.Fatalf("node %q has no value and %d children", path, len(n.children))
+		}
+	}
+
+	count := 0
+	if n.hasValue {
+		if n.key != path {
+			t.Fatalf("node %q holds the key %q", path, n.key)
+		}
+		count++
+	}
+
+	for i, child := range n.children {
+		if i > 0 && n.children[i-1].prefix[0] >= child.prefix[0] {
+			t.Fatalf("the children of %q are not sorted", path)
+		}
+		count += validateNode(t, child, path+child.prefix, false)
+	}
+	return count
+}
+
+func validateTree(t *testing.T, tree *PersistentRadixTree[string, int], expected map[string]int) {
+	t.Helper()
+
+	if tree.root != nil {
+		if count := validateNode(t, tree.root, "", true); count != tree.Len() {
+			t.Fatalf("the tree holds %d values, Len() = %d", count, tree.Len())
+		}
+	}
+
+	if tree.Len() != len(expected) {
+		t.Fatalf("Len() = %d, want %d", tree.Len(), len(expected))
+	}
+
+	keys := make([]string, 0, len(expected))
+	for k := range expected {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var got []string
+	tree.Ascend(func(k string, v int) bool {
+		if v != expected[k] {
+			t.Fatalf("Ascend() visited %q: %d, want %d", k, v, expected[k])
+		}
+		got = append(got, k)
+		return false
+	})
+	if !slices.Equal(got, keys) && len(keys) > 0 {
+		t.Fatalf("Ascend() visited %v, want %v", got, keys)
+	}
+}
+
+// randomKey returns a short key over a small alphabet, so the keys share many prefixes.
+func randomKey() string {
+	b := make([]byte, rand.Intn(6))
+	for i := range b {
+		b[i] = "abc/"[rand.Intn(4)]
+	}
+	return string(b)
+}
+
+func TestPersistentRadixTree(t *testing.T) {
+	t.Run("Random", func(t *testing.T) {
+		tree := NewPersistentRadixTree[string, int]()
+		expected := make(map[string]int)
+
+		type version struct {
+			tree     *PersistentRadixTree[string, int]
+			expected map[string]int
+		}
+		var versions []version
+
+		for i := 0; i < 20000; i++ {
+			k := randomKey()
+			want, wantOk := expected[k]
+
+			if rand.Intn(3) == 0 {
+				if v, ok := tree.Delete(k); v != want || ok != wantOk {
+					t.Fatalf("Delete(%q) = %d, %v, want %d, %v", k, v, ok, want, wantOk)
+				}
+				delete(expected, k)
+			} else {
+				if old, ok := tree.Insert(k, i); old != want || ok != wantOk {
+					t.Fatalf("Insert(%q) = %d, %v, want %d, %v", k, old, ok, want, wantOk)
+				}
+				expected[k] = i
+			}
+
+			want, wantOk = expected[k]
+			if v, ok := tree.Get(k); v != want || ok != wantOk {
+				t.Fatalf("Get(%q) = %d, %v, want %d, %v", k, v, ok, want, wantOk)
+			}
+
+			if i%1000 == 999 {
+				validateTree(t, tree, expected)
+
+				snapshot := make(map[string]int, len(expected))
+				for k, v := range expected {
+					snapshot[k] = v
+				}
+				versions = append(versions, version{tree.Clone(), snapshot})
+			}
+		}
+
+		for _, v := range versions {
+			validateTree(t, v.tree, v.expected)
+		}
+
+		for k := range expected {
+			tree.Delete(k)
+		}
+		validateTree(t, tree, map[string]int{})
+
+		for _, v := range versions {
+			validateTree(t, v.tree, v.expected)
+			v.tree.Destroy()
+		}
+	})
+
+	t.Run("CopyOnWrite", func(t *testing.T) {
+		tree := NewPersistentRadixTree[string, int]()
+		for _, k := range []string{"/api/v1/users", "/api/v1/groups", "/api/v2/users", "/static", "/"} {
+			tree.Insert(k, len(k))
+		}
+
+		clone := tree.Clone()
+		clone.Insert("/api/v1/users/me", 0)
+
+		// only the path to the key is copied: the root, /, /api/v, 1/ and users
+		copied := 0
+		var walk func(n1, n2 *node[int])
+		walk = func(n1, n2 *node[int]) {
+			if n1 == n2 {
+				return
+			}
+			copied++
+			for i := range n1.children {
+				walk(n1.children[i], n2.children[i])
+			}
+		}
+		walk(tree.root, clone.root)
+
+		if copied != 5 {
+			t.Errorf("%d nodes were copied, want 5", copied)
+		}
+		if _, ok := tree.Get("/api/v1/users/me"); ok {
+			t.Errorf("changing a clone should not change the original")
+		}
+
+		clone.Destroy()
+		var check func(n *node[int])
+		check = func(n *node[int]) {
+			if n.refCount != 1 {
+				t.Fatalf("node %q refCount = %d after destroying the clone, want 1", n.prefix, n.refCount)
+			}
+			for _, child := range n.children {
+				check(child)
+			}
+		}
+		check(tree.root)
+	})
+}
+
+func TestPersistentRadixTreePrefix(t *testing.T) {
+	tree := NewPersistentRadixTree[string, int]()
+	keys := make(map[string]int)
+	for i := 0; i < 500; i++ {
+		k := randomKey()
+		tree.Insert(k, i)
+		keys[k] = i
+	}
+
+	for i := 0; i < 200; i++ {
+		prefix := randomKey()
+
+		var want []string
+		for k := range keys {
+			if strings.HasPrefix(k, prefix) {
+				want = append(want, k)
+			}
+		}
+		sort.Strings(want)
+
+		var got []string
+		tree.WalkPrefix(prefix, func(k string, v int) bool {
+			got = append(got, k)
+			return false
+		})
+		if !slices.Equal(got, want) {
+			t.Fatalf("WalkPrefix(%q) = %v, want %v", prefix, got, want)
+		}
+
+		wantKey, wantOk := "", false
+		for k := range keys {
+			if strings.HasPrefix(prefix, k) && (!wantOk || len(k) > len(wantKey)) {
+				wantKey, wantOk = k, true
+			}
+		}
+		if k, v, ok := tree.LongestPrefix(prefix); ok != wantOk || k != wantKey || (ok && v != keys[k]) {
+			t.Fatalf("LongestPrefix(%q) = %q, %d, %v, want %q, %v", prefix, k, v, ok, wantKey, wantOk)
+		}
+	}
+
+	count := 0
+	tree.WalkPrefix("", func(k string, v int) bool {
+		count++
+		return count == 10
+	})
+	if count != 10 {
+		t.Errorf("WalkPrefix() should stop when f returns true")
+	}
+}
+
+func TestPersistentRadixTreeBytes(t *testing.T) {
+	tree := NewPersistentRadixTree[[]byte, string]()
+	tree.Insert([]byte("feature.search.enabled"), "on")
+	tree.Insert([]byte("feature.search.ranking"), "v2")
+	tree.Insert([]byte("feature.billing"), "off")
+
+	if v, ok := tree.Get([]byte("feature.billing")); !ok || v != "off" {
+		t.Errorf("Get() = %q, %v", v, ok)
+	}
+
+	var keys []string
+	tree.WalkPrefix([]byte("feature.search."), func(k []byte, v string) bool {
+		keys = append(keys, string(k))
+		return false
+	})
+	if !slices.Equal(keys, []string{"feature.search.enabled", "feature.search.ranking"}) {
+		t.Errorf("WalkPrefix() = %v", keys)
+	}
+
+	if k, _, ok := tree.LongestPrefix([]byte("feature.billing.plan")); !ok || string(k) != "feature.billing" {
+		t.Errorf("LongestPrefix() = %q, %v", k, ok)
+	}
+}
+
+func BenchmarkPersistentRadixTree(b *testing.B) {
+	keys := make([]string, 100000)
+	for i := range keys {
+		keys[i] = "/api/v" + string(rune('0'+i%10)) + "/" + strings.Repeat("x", i%7) + "/" + string(rune('a'+i%26)) + "/" + string(rune(i))
+	}
+
+	tree := NewPersistentRadixTree[string, int]()
+	for i, k := range keys {
+		tree.Insert(k, i)
+	}
+
+	b.Run("InsertClone", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			clone := tree.Clone()
+			clone.Insert(keys[i%len(keys)], -i)
+			clone.Destroy()
+		}
+	})
+
+	b.Run("Get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tree.Get(keys[i%len(keys)])
+		}
+	})
+}